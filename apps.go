@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// AppList holds the --check-app ids. It implements flag.Value so the flag
+// can be repeated or given a comma-separated list.
+type AppList []string
+
+func (a *AppList) String() string {
+	return strings.Join(*a, ",")
+}
+
+func (a *AppList) Set(value string) error {
+	for _, id := range strings.Split(value, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			return fmt.Errorf("empty app id in %q", value)
+		}
+		*a = append(*a, id)
+	}
+	return nil
+}
+
+// fetchEnabledApps lists the enabled apps through the provisioning API. The
+// NC-Token only grants access to serverinfo, so the request authenticates
+// as an admin, preferably with an app password.
+func fetchEnabledApps(ctx context.Context, client *http.Client, cfg Config) (map[string]bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", cfg.ServerURL+"/ocs/v1.php/cloud/apps?filter=enabled&format=json", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(cfg.AppsUser, cfg.AppsPassword)
+	req.Header.Set("OCS-APIRequest", "true")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "check_nextcloud/1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, &AuthError{StatusCode: resp.StatusCode}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var appsResp struct {
+		OCS struct {
+			Meta MetaInfo `json:"meta"`
+			Data struct {
+				Apps []string `json:"apps"`
+			} `json:"data"`
+		} `json:"ocs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&appsResp); err != nil {
+		return nil, fmt.Errorf("invalid apps response: %v", err)
+	}
+	if code := appsResp.OCS.Meta.StatusCode; code != 0 && code != 100 && code != 200 {
+		return nil, &OCSError{StatusCode: code, Message: appsResp.OCS.Meta.Message}
+	}
+
+	enabled := make(map[string]bool, len(appsResp.OCS.Data.Apps))
+	for _, id := range appsResp.OCS.Data.Apps {
+		enabled[id] = true
+	}
+	return enabled, nil
+}
+
+// appMetricPrefix prefixes the perfdata keys of --app-count metrics.
+const appMetricPrefix = "app_"
+
+var appCountNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// AppCount is an app-specific count queried by --app-count, e.g. the deck
+// boards. Field is the dotted path to the value in the JSON response, empty
+// for the whole response.
+type AppCount struct {
+	Name  string
+	Path  string
+	Field string
+}
+
+// AppCounts holds the --app-count endpoints. It implements flag.Value so
+// the flag can be repeated.
+type AppCounts []AppCount
+
+func (a *AppCounts) String() string {
+	specs := make([]string, 0, len(*a))
+	for _, count := range *a {
+		spec := count.Name + "=" + count.Path
+		if count.Field != "" {
+			spec += "#" + count.Field
+		}
+		specs = append(specs, spec)
+	}
+	return strings.Join(specs, ",")
+}
+
+// Set parses NAME=PATH[#FIELD]. PATH is relative to the server URL, without
+// FIELD the whole response is counted.
+func (a *AppCounts) Set(spec string) error {
+	name, target, ok := strings.Cut(spec, "=")
+	if !ok || !strings.HasPrefix(target, "/") {
+		return fmt.Errorf("expected name=/path[#field], got %q", spec)
+	}
+	if !appCountNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid name %q (lowercase letters, digits and _)", name)
+	}
+	path, field, _ := strings.Cut(target, "#")
+	*a = append(*a, AppCount{Name: name, Path: path, Field: field})
+	return nil
+}
+
+// fetchAppCount queries the endpoint of count and returns the value at its
+// field: numbers as is, arrays and objects by their number of elements.
+// Requests authenticate as --apps-user when set.
+func fetchAppCount(ctx context.Context, client *http.Client, cfg Config, count AppCount) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", cfg.ServerURL+count.Path, nil)
+	if err != nil {
+		return 0, err
+	}
+	if cfg.AppsUser != "" {
+		req.SetBasicAuth(cfg.AppsUser, cfg.AppsPassword)
+	}
+	req.Header.Set("OCS-APIRequest", "true")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "check_nextcloud/1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var value interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&value); err != nil {
+		return 0, fmt.Errorf("invalid response: %v", err)
+	}
+	for _, key := range strings.Split(count.Field, ".") {
+		if key == "" {
+			continue
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return 0, fmt.Errorf("no field %q in response", count.Field)
+		}
+		if value, ok = object[key]; !ok {
+			return 0, fmt.Errorf("no field %q in response", count.Field)
+		}
+	}
+
+	switch value := value.(type) {
+	case float64:
+		return int(value), nil
+	case []interface{}:
+		return len(value), nil
+	case map[string]interface{}:
+		return len(value), nil
+	}
+	return 0, fmt.Errorf("value of %q is not a number, array or object", count.Field)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// baselineFacts lists the configuration facts compared with --baseline-file,
+// in report order.
+var baselineFacts = []string{"php_version", "db_type", "num_apps_installed", "edition", "webserver"}
+
+// collectFacts returns the current value of every baseline fact.
+func collectFacts(data DataInfo) map[string]string {
+	return map[string]string{
+		"php_version":        data.Server.PHP.Version,
+		"db_type":            data.Server.Database.Type,
+		"num_apps_installed": strconv.Itoa(data.Nextcloud.System.Apps.NumInstalled),
+		"edition":            data.Nextcloud.System.Edition,
+		"webserver":          data.Server.Webserver,
+	}
+}
+
+// loadBaseline reads the baseline file. A missing file yields nil without
+// error so the first run can record it.
+func loadBaseline(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var baseline map[string]string
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, err
+	}
+	return baseline, nil
+}
+
+// saveBaseline writes facts to the baseline file. Failures are reported on
+// stderr only.
+func saveBaseline(path string, facts map[string]string) {
+	data, err := json.MarshalIndent(facts, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode baseline file: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write baseline file: %v\n", err)
+	}
+}
+
+// baselineDrift describes every fact that differs from the baseline as
+// "fact: old -> new". Facts missing from the baseline are not compared.
+func baselineDrift(baseline, facts map[string]string) []string {
+	var drift []string
+	for _, fact := range baselineFacts {
+		old, ok := baseline[fact]
+		if ok && old != facts[fact] {
+			drift = append(drift, fmt.Sprintf("%s: %s -> %s", fact, old, facts[fact]))
+		}
+	}
+	return drift
+}
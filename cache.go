@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CacheEntry is a serverinfo response cached by --cache-ttl.
+type CacheEntry struct {
+	FetchedAt int64        `json:"fetched_at"`
+	BodyBytes int          `json:"body_bytes"`
+	Response  *OCSResponse `json:"response"`
+	// Status is the status.php response of --check-upgrade, nil when the
+	// caching run did not query it.
+	Status *StatusInfo `json:"status,omitempty"`
+}
+
+// cachePath returns the cache file of the instance. The name is a hash of
+// URL, token and endpoints, so checks with different credentials never
+// share an entry.
+func cachePath(cfg Config) string {
+	sum := sha256.Sum256([]byte(cfg.ServerURL + "\n" + cfg.Token + "\n" + strings.Join(cfg.APIPaths, ",")))
+	return filepath.Join(os.TempDir(), "check_nextcloud-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// loadCache returns the cached entry when it is younger than ttl. A
+// missing, expired or unreadable entry yields nil.
+func loadCache(path string, ttl time.Duration, now time.Time) (*CacheEntry, time.Duration) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0
+	}
+	var entry CacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Response == nil {
+		return nil, 0
+	}
+	age := now.Sub(time.Unix(entry.FetchedAt, 0))
+	if age < 0 || age >= ttl {
+		return nil, 0
+	}
+	entry.Response.BodyBytes = entry.BodyBytes
+	return &entry, age
+}
+
+// saveCache writes the response and the optional status.php response
+// atomically and readable by the owner only, as serverinfo details are not
+// public. Failures are reported on stderr only and never change the check
+// result.
+func saveCache(path string, ocsResp *OCSResponse, instanceStatus *StatusInfo, now time.Time) {
+	data, err := json.Marshal(CacheEntry{FetchedAt: now.Unix(), BodyBytes: ocsResp.BodyBytes, Response: ocsResp, Status: instanceStatus})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode cache file: %v\n", err)
+		return
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write cache file: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write cache file: %v\n", err)
+	}
+}
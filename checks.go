@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+type CheckInfo struct {
+	Name       string
+	Metrics    []string
+	Thresholds bool
+	// Flags lists the command line flags that configure the check.
+	Flags []string
+	// Configured reports whether the check has thresholds set for this run.
+	// Nil means the check always runs with built-in thresholds. Unconfigured
+	// checks are handled according to --unconfigured-checks.
+	Configured func(cfg Config) bool
+}
+
+func never(Config) bool { return false }
+
+// checks lists every check evaluated by checkNextcloud. Keep it in sync when
+// adding or changing a check, it backs the --list-checks and --list-modes
+// output.
+var checks = []CheckInfo{
+	{Name: "status", Metrics: []string{}},
+	{Name: "serverinfo", Metrics: []string{"response_bytes"}, Configured: never},
+	{Name: "https", Metrics: []string{}, Flags: []string{"allow-http", "require-https"}},
+	{Name: "cpu_load", Metrics: []string{"cpu_load_1m", "cpu_load_5m", "cpu_load_15m", "ncpu", "cpu_steal_percent", "cpu_iowait_percent", "active_users_5m"}, Flags: []string{"cpu-load-warn", "cpu-expected-users", "cpu-steal-percent", "cpu-steal-state"}, Thresholds: true},
+	{Name: "memory", Metrics: []string{"memory_usage_percent"}, Flags: []string{"mem-available"}},
+	{Name: "memory_growth", Metrics: []string{"memory_usage_delta"}, Flags: []string{"memory-growth-warn", "state-file"}, Thresholds: true},
+	{Name: "files_drop", Metrics: []string{"num_files_change_percent"}, Flags: []string{"files-drop-crit", "state-file"}, Thresholds: true},
+	{Name: "files_growth", Metrics: []string{"num_files_per_day", "num_files_days_until_limit"}, Flags: []string{"files-window", "files-limit", "files-horizon-days", "state-file"}, Thresholds: true},
+	{Name: "storages_growth", Metrics: []string{"num_storages_delta"}, Flags: []string{"storages-growth-warn", "state-file"}, Thresholds: true},
+	{Name: "swap", Metrics: []string{"swap_usage_percent"}},
+	{Name: "swap_configured", Metrics: []string{"swap_total"}, Flags: []string{"require-swap"}},
+	{Name: "app_updates", Metrics: []string{"num_apps_update_available"}, Flags: []string{"updates-warn"}, Thresholds: true},
+	{Name: "nextcloud_update", Metrics: []string{}},
+	{Name: "data_readonly", Metrics: []string{}},
+	{Name: "upgrade", Metrics: []string{}, Flags: []string{"check-upgrade"}},
+	{Name: "app_counts", Metrics: []string{}, Flags: []string{"app-count", "apps-user", "apps-password"}, Configured: func(cfg Config) bool { return len(cfg.AppCounts) > 0 }},
+	{Name: "required_apps", Metrics: []string{}, Flags: []string{"check-app", "apps-user", "apps-password"}, Configured: func(cfg Config) bool { return len(cfg.RequiredApps) > 0 }},
+	{Name: "talk_hpb", Metrics: []string{}, Flags: []string{"talk-hpb-url"}},
+	{Name: "min_version", Metrics: []string{}, Flags: []string{"min-version", "min-version-critical"}, Thresholds: true},
+	{Name: "edition", Metrics: []string{}, Flags: []string{"expected-edition"}},
+	{Name: "locale", Metrics: []string{}, Flags: []string{"expected-timezone", "expected-locale"}},
+	{Name: "database", Metrics: []string{"db_queries", "db_slow_queries", "db_slow_query_percent"}, Flags: []string{"db-slow-query-percent-warn"}, Thresholds: true},
+	{Name: "db_migrations", Metrics: []string{"db_pending_migrations"}},
+	{Name: "shares_per_user", Metrics: []string{"shares_per_user"}, Flags: []string{"shares-per-user-warn"}, Thresholds: true},
+	{Name: "user_cap", Metrics: []string{"num_users_percent"}, Flags: []string{"user-cap", "users-percent-warn", "users-percent-crit"}, Thresholds: true, Configured: func(cfg Config) bool { return cfg.UserCap > 0 }},
+	{Name: "trusted_domains", Metrics: []string{}, Flags: []string{"check-trusted-domains"}},
+	{Name: "reverse_proxy", Metrics: []string{}, Flags: []string{"check-proxy-headers", "expected-base-url"}},
+	{Name: "server_identity", Metrics: []string{}, Flags: []string{"check-server-identity", "expected-identity", "identity-header"}},
+	{Name: "baseline", Metrics: []string{}, Flags: []string{"baseline-file", "write-baseline"}},
+	{Name: "version_regression", Metrics: []string{}, Flags: []string{"check-downgrade", "state-file"}},
+	{Name: "external_storage", Metrics: []string{"external_storage_usage_percent"}, Flags: []string{"external-storage-warn", "external-storage-crit"}, Thresholds: true},
+	{Name: "object_storage", Metrics: []string{"object_storage_latency_ms"}, Flags: []string{"object-storage-url", "object-storage-latency-warn"}, Thresholds: true},
+	{Name: "clock_skew", Metrics: []string{"clock_skew_seconds"}, Flags: []string{"max-skew"}, Thresholds: true},
+	{Name: "logging", Metrics: []string{"logfile_size_bytes"}, Flags: []string{"production", "max-log-size"}, Thresholds: true},
+	{Name: "security_scan", Metrics: []string{}, Flags: []string{"security-scan-min-grade", "security-scan-max-age", "state-file"}, Thresholds: true},
+	{Name: "opcache", Metrics: []string{"opcache_hit_rate"}, Flags: []string{"opcache-disabled-state", "opcache-hit-rate-warn", "opcache-hit-rate-crit"}, Thresholds: true},
+	{Name: "opcache_keys", Metrics: []string{"opcache_cached_scripts", "opcache_cached_keys_percent"}, Flags: []string{"opcache-keys-percent-warn"}, Thresholds: true},
+	{Name: "php_memory", Metrics: []string{"php_opcache_memory_percent", "php_interned_strings_percent"}, Flags: []string{"php-memory-limit"}, Configured: never},
+	{Name: "opcache_restarts", Metrics: []string{"opcache_oom_restarts", "opcache_hash_restarts", "opcache_manual_restarts", "opcache_oom_restart_rate"}, Flags: []string{"opcache-oom-restarts-warn", "state-file"}, Thresholds: true},
+	{Name: "active_users", Metrics: []string{"active_users_5m", "active_users_1h", "active_users_24h", "active_users_7d", "active_users_1mo", "active_users_3mo", "active_users_6mo", "active_users_1y"}, Flags: []string{"business-hours"}, Configured: func(cfg Config) bool { return cfg.BusinessHours != nil }},
+	{Name: "storage", Metrics: []string{"num_users", "num_files", "num_shares", "num_storages"}, Configured: never},
+}
+
+// unconfiguredMetrics returns the metrics that only belong to checks without
+// configured thresholds. Metrics shared with a configured check are kept.
+func unconfiguredMetrics(cfg Config) map[string]bool {
+	unconfigured := map[string]bool{}
+	for _, check := range checks {
+		if check.Configured != nil && !check.Configured(cfg) {
+			for _, metric := range check.Metrics {
+				unconfigured[metric] = true
+			}
+		}
+	}
+	for _, check := range checks {
+		if check.Configured == nil || check.Configured(cfg) {
+			for _, metric := range check.Metrics {
+				delete(unconfigured, metric)
+			}
+		}
+	}
+	return unconfigured
+}
+
+func isCheck(name string) bool {
+	for _, check := range checks {
+		if check.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func listChecks() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tMETRICS\tTHRESHOLDS")
+	for _, check := range checks {
+		metrics := "-"
+		if len(check.Metrics) > 0 {
+			metrics = strings.Join(check.Metrics, ",")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", check.Name, metrics, thresholdKind(check))
+	}
+	w.Flush()
+}
+
+// ModeFlag describes a command line flag in the --list-modes output.
+type ModeFlag struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Default string `json:"default"`
+	Usage   string `json:"usage"`
+}
+
+// ModeInfo describes a --mode value in the --list-modes output.
+type ModeInfo struct {
+	Name       string     `json:"name"`
+	Metrics    []string   `json:"metrics"`
+	Thresholds string     `json:"thresholds"`
+	Flags      []ModeFlag `json:"flags"`
+}
+
+// ModeList is the --list-modes json document. Flags not tied to a single
+// check are listed under global_flags.
+type ModeList struct {
+	Modes       []ModeInfo `json:"modes"`
+	GlobalFlags []ModeFlag `json:"global_flags"`
+}
+
+// describeFlag reads name, type and default straight from the registered
+// flag so the output cannot drift from the actual flag set.
+func describeFlag(f *flag.Flag) ModeFlag {
+	flagType := "string"
+	if getter, ok := f.Value.(flag.Getter); ok {
+		flagType = fmt.Sprintf("%T", getter.Get())
+	}
+	return ModeFlag{Name: f.Name, Type: flagType, Default: f.DefValue, Usage: f.Usage}
+}
+
+func thresholdKind(check CheckInfo) string {
+	switch {
+	case check.Thresholds:
+		return "configurable"
+	case check.Configured != nil:
+		return "none"
+	default:
+		return "fixed"
+	}
+}
+
+func listModes() error {
+	list := ModeList{Modes: []ModeInfo{}, GlobalFlags: []ModeFlag{}}
+	checkFlags := map[string]bool{}
+
+	for _, check := range checks {
+		mode := ModeInfo{Name: check.Name, Metrics: check.Metrics, Thresholds: thresholdKind(check), Flags: []ModeFlag{}}
+		for _, name := range check.Flags {
+			if f := flag.Lookup(name); f != nil {
+				mode.Flags = append(mode.Flags, describeFlag(f))
+				checkFlags[name] = true
+			}
+		}
+		list.Modes = append(list.Modes, mode)
+	}
+
+	flag.VisitAll(func(f *flag.Flag) {
+		if !checkFlags[f.Name] {
+			list.GlobalFlags = append(list.GlobalFlags, describeFlag(f))
+		}
+	})
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
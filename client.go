@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// newHTTPClient builds the HTTP client used for all requests of a check run.
+func newHTTPClient(cfg Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	// The connect timeout only bounds establishing the TCP connection, a
+	// slow serverinfo response is still limited by the overall timeout.
+	if cfg.ConnectTimeout > 0 {
+		dialer := &net.Dialer{Timeout: cfg.ConnectTimeout, KeepAlive: 30 * time.Second}
+		transport.DialContext = dialer.DialContext
+	}
+
+	// Without --proxy the standard HTTP_PROXY/HTTPS_PROXY/NO_PROXY
+	// environment variables apply. Credentials are sent as
+	// Proxy-Authorization by the transport.
+	if cfg.Proxy != "" {
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, &ConnectError{Op: "Invalid proxy URL", Err: err}
+		}
+		if cfg.ProxyUser != "" {
+			proxyURL.User = url.UserPassword(cfg.ProxyUser, cfg.ProxyPassword)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if cfg.UnixSocket != "" {
+		info, err := os.Stat(cfg.UnixSocket)
+		if err != nil {
+			return nil, &ConnectError{Op: "Invalid unix socket", Err: err}
+		}
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, &ConnectError{Op: "Invalid unix socket", Err: fmt.Errorf("%s is not a socket", cfg.UnixSocket)}
+		}
+
+		serverAddr, err := dialAddress(cfg.ServerURL)
+		if err != nil {
+			return nil, &ConnectError{Op: "Invalid server URL", Err: err}
+		}
+
+		// The request URL (and therefore the Host header) still comes from
+		// cfg.ServerURL, only connections to the server are redirected to the
+		// socket. Companion services like the Talk HPB are dialed normally.
+		socket := cfg.UnixSocket
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr != serverAddr {
+				return dial(ctx, network, addr)
+			}
+			d := net.Dialer{Timeout: cfg.ConnectTimeout}
+			return d.DialContext(ctx, "unix", socket)
+		}
+	}
+
+	var roundTripper http.RoundTripper = transport
+	if cfg.CFClientID != "" || cfg.HostHeader != "" || cfg.HTTP2Only {
+		serverAddr, err := dialAddress(cfg.ServerURL)
+		if err != nil {
+			return nil, &ConnectError{Op: "Invalid server URL", Err: err}
+		}
+		headers := http.Header{}
+		if cfg.CFClientID != "" {
+			headers.Set("CF-Access-Client-Id", cfg.CFClientID)
+			headers.Set("CF-Access-Client-Secret", cfg.CFClientSecret)
+		}
+
+		// With --host-header the server gets its own transport so the TLS
+		// SNI and certificate name follow the virtual host, while companion
+		// services keep verifying their own names.
+		// --http2-only requests h2 during the TLS handshake. Go still offers
+		// http/1.1 as well, so a server may answer over HTTP/1.1 anyway,
+		// which http2OnlyTransport rejects.
+		server := transport
+		if cfg.HostHeader != "" || cfg.HTTP2Only {
+			server = transport.Clone()
+			if server.TLSClientConfig == nil {
+				server.TLSClientConfig = &tls.Config{}
+			}
+			if cfg.HostHeader != "" {
+				server.TLSClientConfig.ServerName = hostWithoutPort(cfg.HostHeader)
+			}
+			if cfg.HTTP2Only {
+				server.ForceAttemptHTTP2 = true
+				server.TLSClientConfig.NextProtos = []string{"h2"}
+			}
+		}
+		var serverRoundTripper http.RoundTripper = server
+		if cfg.HTTP2Only {
+			serverRoundTripper = &http2OnlyTransport{base: server}
+		}
+
+		roundTripper = &serverTransport{base: transport, server: serverRoundTripper, serverAddr: serverAddr, headers: headers, host: cfg.HostHeader}
+	}
+
+	// The overall timeout is enforced through the request context, see
+	// checkNextcloud.
+	return &http.Client{
+		Transport: roundTripper,
+	}, nil
+}
+
+// serverTransport adds headers and the Host override to requests sent to the
+// Nextcloud server only, so access credentials never reach companion
+// services or redirect targets on other hosts. Server requests go through
+// server, all others through base.
+type serverTransport struct {
+	base       http.RoundTripper
+	server     http.RoundTripper
+	serverAddr string
+	headers    http.Header
+	host       string
+}
+
+func (t *serverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	addr, err := dialAddress(req.URL.String())
+	if err != nil || addr != t.serverAddr {
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	for name, values := range t.headers {
+		req.Header[name] = values
+	}
+	if t.host != "" {
+		req.Host = t.host
+	}
+	return t.server.RoundTrip(req)
+}
+
+// http2OnlyTransport fails responses that were not received over HTTP/2.
+type http2OnlyTransport struct {
+	base http.RoundTripper
+}
+
+func (t *http2OnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ProtoMajor != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("server answered over %s, --http2-only requires HTTP/2", resp.Proto)
+	}
+	return resp, nil
+}
+
+// hostWithoutPort strips an optional port from a Host header value.
+func hostWithoutPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// dialAddress returns the host:port the transport dials for rawURL.
+func dialAddress(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
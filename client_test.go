@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConnectTimeoutSilentServer checks a host that accepts the connection
+// but never responds: the connect timeout must not cut the established
+// connection short, the overall timeout ends the request.
+func TestConnectTimeoutSilentServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	cfg := testFetchConfig("http://" + listener.Addr().String())
+	cfg.ConnectTimeout = 50 * time.Millisecond
+	cfg.Timeout = 300 * time.Millisecond
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+	start := time.Now()
+	_, _, err = fetchServerInfo(ctx, client, cfg)
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context deadline exceeded", err)
+	}
+	if elapsed < cfg.Timeout {
+		t.Errorf("request ended after %v, before the overall timeout of %v", elapsed, cfg.Timeout)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("request took %v with a %v timeout", elapsed, cfg.Timeout)
+	}
+	if got := exitCodeForError(err); got != StateCritical {
+		t.Errorf("exit state = %d, want %d", got, StateCritical)
+	}
+}
+
+// TestHTTP2Only checks that --http2-only requests h2 and fails a server
+// that answers over HTTP/1.1.
+func TestHTTP2Only(t *testing.T) {
+	tests := []struct {
+		name    string
+		http2   bool
+		wantErr bool
+	}{
+		{"http2 server", true, false},
+		{"http1 only server", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var offered []string
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.Proto))
+			}))
+			server.EnableHTTP2 = tt.http2
+			server.TLS = &tls.Config{
+				GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+					mu.Lock()
+					offered = hello.SupportedProtos
+					mu.Unlock()
+					return nil, nil
+				},
+			}
+			server.StartTLS()
+			defer server.Close()
+
+			// newHTTPClient starts from http.DefaultTransport, let it trust
+			// the test server certificate.
+			defaultTransport := http.DefaultTransport.(*http.Transport)
+			tlsConfig := defaultTransport.TLSClientConfig
+			defaultTransport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+			defer func() { defaultTransport.TLSClientConfig = tlsConfig }()
+
+			cfg := testFetchConfig(server.URL)
+			cfg.HTTP2Only = true
+			client, err := newHTTPClient(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !slices.Contains(offered, "h2") {
+				t.Errorf("offered ALPN protocols = %q, want h2", offered)
+			}
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && resp.ProtoMajor != 2 {
+				t.Errorf("response protocol = %s, want HTTP/2", resp.Proto)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// CompareFact is a serverinfo value compared by --compare-with.
+type CompareFact struct {
+	Name  string
+	Value interface{}
+}
+
+// compareFacts returns the compared values of data in output order.
+func compareFacts(data DataInfo) []CompareFact {
+	return []CompareFact{
+		{"version", data.Nextcloud.System.Version},
+		{"num_users", data.Nextcloud.Storage.NumUsers},
+		{"num_files", data.Nextcloud.Storage.NumFiles},
+		{"num_apps_installed", data.Nextcloud.System.Apps.NumInstalled},
+	}
+}
+
+// fetchInstance fetches and sanitizes the serverinfo of a single instance.
+func fetchInstance(ctx context.Context, cfg Config) (*DataInfo, error) {
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ocsResp, _, err := fetchServerInfoRetry(ctx, client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := sanitizeServerInfo(cfg, &ocsResp.OCS.Data); err != nil {
+		return nil, err
+	}
+	return &ocsResp.OCS.Data, nil
+}
+
+// compareInstances fetches serverinfo from cfg.ServerURL and other and
+// warns when the version differs or a count diverges by more than
+// tolerance percent of the first instance, e.g. to validate a migration.
+// Every compared value is listed in the long output.
+func compareInstances(ctx context.Context, cfg Config, other Instance, tolerance float64) (string, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	data, err := fetchInstance(ctx, cfg)
+	if err != nil {
+		return "", 0, err
+	}
+	otherCfg := cfg
+	otherCfg.ServerURL = other.ServerURL
+	otherCfg.Token = other.Token
+	otherData, err := fetchInstance(ctx, otherCfg)
+	if err != nil {
+		return "", 0, fmt.Errorf("%s: %w", other.ServerURL, err)
+	}
+
+	facts, otherFacts := compareFacts(*data), compareFacts(*otherData)
+	var differing, lines []string
+	for i, fact := range facts {
+		line := fmt.Sprintf("%s: %v vs %v", fact.Name, fact.Value, otherFacts[i].Value)
+		diverged := fact.Value != otherFacts[i].Value
+		if count, ok := fact.Value.(int); ok {
+			otherCount := otherFacts[i].Value.(int)
+			if count != otherCount {
+				change := math.Inf(1)
+				if count != 0 {
+					change = float64(otherCount-count) / float64(count) * 100
+					line += fmt.Sprintf(" (%+.1f%%)", change)
+				}
+				diverged = math.Abs(change) > tolerance
+			}
+		}
+		if diverged {
+			differing = append(differing, fact.Name)
+		}
+		lines = append(lines, line)
+	}
+
+	summary := fmt.Sprintf("OK - %s matches %s", other.ServerURL, cfg.ServerURL)
+	exitCode := StateOK
+	if len(differing) > 0 {
+		summary = fmt.Sprintf("WARNING - %s differs from %s (%s)", other.ServerURL, cfg.ServerURL, strings.Join(differing, ", "))
+		exitCode = StateWarning
+	}
+	return pluginText(summary + "\n" + strings.Join(lines, "\n")), exitCode, nil
+}
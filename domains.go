@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// hostTrusted reports whether the host of serverURL matches one of the
+// trusted domains. The comparison is case-insensitive, accepts entries with or
+// without a port (an explicit default port matches a URL without one) and
+// supports Nextcloud's "*" wildcards.
+func hostTrusted(serverURL string, domains []string) bool {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return false
+	}
+
+	candidates := []string{strings.ToLower(u.Hostname()), strings.ToLower(u.Host)}
+	if addr, err := dialAddress(serverURL); err == nil {
+		candidates = append(candidates, strings.ToLower(addr))
+	}
+
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if host, port, err := net.SplitHostPort(domain); err == nil {
+			domain = net.JoinHostPort(host, port)
+		}
+		for _, candidate := range candidates {
+			if matched, _ := path.Match(domain, candidate); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	StateOK       = 0
+	StateWarning  = 1
+	StateCritical = 2
+	StateUnknown  = 3
+)
+
+var stateNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// stateByName returns the state for a case-insensitive state name, or -1.
+func stateByName(name string) int {
+	for i, stateName := range stateNames {
+		if strings.EqualFold(strings.TrimSpace(name), stateName) {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseExitMap parses an --exit-map spec like "warning=0,unknown=2" into a
+// table indexed by the internal state. States not mentioned keep their
+// standard Nagios exit code.
+func parseExitMap(spec string) ([4]int, error) {
+	exitMap := [4]int{StateOK, StateWarning, StateCritical, StateUnknown}
+	if spec == "" {
+		return exitMap, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return exitMap, fmt.Errorf("expected state=code, got %q", pair)
+		}
+
+		state := stateByName(name)
+		if state < 0 {
+			return exitMap, fmt.Errorf("unknown state %q", name)
+		}
+
+		code, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || code < 0 || code > 255 {
+			return exitMap, fmt.Errorf("invalid exit code %q", value)
+		}
+		exitMap[state] = code
+	}
+
+	return exitMap, nil
+}
+
+// AuthError is returned when the server rejects the NC-Token.
+type AuthError struct {
+	StatusCode int
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("Unauthorized access (%d)", e.StatusCode)
+}
+
+// ConnectError is returned when the request could not be built, sent or its
+// response could not be read.
+type ConnectError struct {
+	Op  string
+	Err error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+func (e *ConnectError) Unwrap() error {
+	return e.Err
+}
+
+// ResolveError is returned when the server hostname could not be resolved.
+type ResolveError struct {
+	Host string
+	Err  error
+}
+
+func (e *ResolveError) Error() string {
+	return fmt.Sprintf("could not resolve host %s", e.Host)
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// ParseError is returned when the response could not be decoded or does not
+// look like a serverinfo response.
+type ParseError struct {
+	Op  string
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	if e.Err == nil {
+		return e.Op
+	}
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// AppNotEnabledError is returned when the instance is reachable but an app
+// required by the check does not answer.
+type AppNotEnabledError struct {
+	App string
+}
+
+func (e *AppNotEnabledError) Error() string {
+	return fmt.Sprintf("%s app not enabled", e.App)
+}
+
+// IncompleteResponseError is returned when the response body ended before
+// the JSON document was complete, usually because the connection dropped.
+type IncompleteResponseError struct {
+	Err error
+}
+
+func (e *IncompleteResponseError) Error() string {
+	return "incomplete response from server (connection may have dropped) - consider increasing --timeout"
+}
+
+func (e *IncompleteResponseError) Unwrap() error {
+	return e.Err
+}
+
+// InvalidMetricError is returned with --strict when serverinfo reports an
+// impossible value, e.g. negative free memory.
+type InvalidMetricError struct {
+	Field string
+	Value string
+}
+
+func (e *InvalidMetricError) Error() string {
+	return fmt.Sprintf("serverinfo reported an invalid %s of %s", e.Field, e.Value)
+}
+
+// MaintenanceError is returned when the server flags its response with the
+// X-Nextcloud-Maintenance-Mode header. State is the configured
+// --maintenance-state.
+type MaintenanceError struct {
+	State int
+}
+
+func (e *MaintenanceError) Error() string {
+	return "Nextcloud is in maintenance mode"
+}
+
+// ContentError is returned when the server answers with something other
+// than the requested format, typically a login or maintenance page.
+type ContentError struct {
+	Message string
+}
+
+func (e *ContentError) Error() string {
+	return e.Message
+}
+
+// XMLResponseError is returned when the server answers with XML although
+// JSON was requested, usually because a proxy or the serverinfo app
+// overrides content negotiation.
+type XMLResponseError struct{}
+
+func (e *XMLResponseError) Error() string {
+	return "received XML instead of JSON - a proxy or the serverinfo app may be overriding content negotiation (format=json)"
+}
+
+// UnreachableError is returned by --probe-first when the instance does not
+// answer at all.
+type UnreachableError struct {
+	Err error
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("Nextcloud instance unreachable: %v", e.Err)
+}
+
+func (e *UnreachableError) Unwrap() error {
+	return e.Err
+}
+
+// EndpointError is returned by --probe-first when the instance answers but
+// the serverinfo request fails.
+type EndpointError struct {
+	Err error
+}
+
+func (e *EndpointError) Error() string {
+	return fmt.Sprintf("serverinfo endpoint problem: %v", e.Err)
+}
+
+func (e *EndpointError) Unwrap() error {
+	return e.Err
+}
+
+// CancelledError is returned when the check was interrupted by SIGINT or
+// SIGTERM before it finished.
+type CancelledError struct {
+	Err error
+}
+
+func (e *CancelledError) Error() string {
+	return "check cancelled"
+}
+
+func (e *CancelledError) Unwrap() error {
+	return e.Err
+}
+
+// AttemptError records the failure of a single serverinfo endpoint.
+type AttemptError struct {
+	Path string
+	Err  error
+}
+
+func (e *AttemptError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *AttemptError) Unwrap() error {
+	return e.Err
+}
+
+// AttemptsError is returned when every configured serverinfo endpoint
+// failed. Its exit code follows the first failed attempt.
+type AttemptsError struct {
+	Errs []error
+}
+
+func (e *AttemptsError) Error() string {
+	msgs := make([]string, 0, len(e.Errs))
+	for _, err := range e.Errs {
+		msgs = append(msgs, err.Error())
+	}
+	return "All serverinfo endpoints failed: " + strings.Join(msgs, "; ")
+}
+
+func (e *AttemptsError) Unwrap() error {
+	return e.Errs[0]
+}
+
+// ocsStatusCodes maps known OCS meta status codes to a message and exit code.
+// Codes not listed here are reported as UNKNOWN with the server's message.
+var ocsStatusCodes = map[int]struct {
+	Message string
+	State   int
+}{
+	996: {"OCS internal server error", StateCritical},
+	997: {"OCS unauthorized - check NC-Token", StateCritical},
+	998: {"OCS endpoint not found - check the serverinfo app", StateUnknown},
+	999: {"OCS unknown error", StateUnknown},
+}
+
+// OCSError is returned when the OCS meta block reports a failure even though
+// the HTTP request succeeded.
+type OCSError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *OCSError) Error() string {
+	msg := fmt.Sprintf("OCS error %d", e.StatusCode)
+	if known, ok := ocsStatusCodes[e.StatusCode]; ok {
+		msg = fmt.Sprintf("%s (%d)", known.Message, e.StatusCode)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// exitCodeForError maps an error returned by checkNextcloud to the plugin
+// exit code. Errors of unknown type are reported as UNKNOWN. Wrapping errors
+// are matched before the errors they wrap.
+func exitCodeForError(err error) int {
+	var authErr *AuthError
+	var connectErr *ConnectError
+	var parseErr *ParseError
+	var appErr *AppNotEnabledError
+	var resolveErr *ResolveError
+	var unreachableErr *UnreachableError
+	var endpointErr *EndpointError
+	var contentErr *ContentError
+	var xmlErr *XMLResponseError
+	var invalidErr *InvalidMetricError
+	var cancelledErr *CancelledError
+	var ocsErr *OCSError
+	var incompleteErr *IncompleteResponseError
+	var maintenanceErr *MaintenanceError
+
+	switch {
+	case errors.As(err, &cancelledErr):
+		return StateUnknown
+	case errors.As(err, &maintenanceErr):
+		return maintenanceErr.State
+	case errors.As(err, &unreachableErr):
+		return StateCritical
+	case errors.As(err, &endpointErr):
+		return StateUnknown
+	case errors.As(err, &resolveErr):
+		return StateUnknown
+	case errors.As(err, &authErr):
+		return StateCritical
+	case errors.As(err, &connectErr):
+		return StateCritical
+	case errors.As(err, &parseErr):
+		return StateCritical
+	case errors.As(err, &appErr):
+		return StateUnknown
+	case errors.As(err, &contentErr):
+		return StateUnknown
+	case errors.As(err, &xmlErr):
+		return StateUnknown
+	case errors.As(err, &invalidErr):
+		return StateUnknown
+	case errors.As(err, &incompleteErr):
+		return StateUnknown
+	case errors.As(err, &ocsErr):
+		if known, ok := ocsStatusCodes[ocsErr.StatusCode]; ok {
+			return known.State
+		}
+		return StateUnknown
+	default:
+		return StateUnknown
+	}
+}
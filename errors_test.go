@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCodeForError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"auth", &AuthError{StatusCode: 401}, StateCritical},
+		{"connect", &ConnectError{Op: "API request failed", Err: errors.New("connection refused")}, StateCritical},
+		{"resolve", &ResolveError{Host: "nc.invalid"}, StateUnknown},
+		{"parse", &ParseError{Op: "Failed to parse JSON"}, StateCritical},
+		{"app not enabled", &AppNotEnabledError{App: "serverinfo"}, StateUnknown},
+		{"incomplete", &IncompleteResponseError{}, StateUnknown},
+		{"invalid metric", &InvalidMetricError{Field: "mem_free", Value: "-1"}, StateUnknown},
+		{"maintenance warning", &MaintenanceError{State: StateWarning}, StateWarning},
+		{"maintenance critical", &MaintenanceError{State: StateCritical}, StateCritical},
+		{"content", &ContentError{Message: "login page"}, StateUnknown},
+		{"xml", &XMLResponseError{}, StateUnknown},
+		{"unreachable", &UnreachableError{Err: errors.New("timeout")}, StateCritical},
+		{"endpoint", &EndpointError{Err: &ConnectError{Op: "API request failed", Err: errors.New("EOF")}}, StateUnknown},
+		{"cancelled", &CancelledError{Err: context.Canceled}, StateUnknown},
+		{"ocs internal error", &OCSError{StatusCode: 996}, StateCritical},
+		{"ocs unauthorized", &OCSError{StatusCode: 997}, StateCritical},
+		{"ocs not found", &OCSError{StatusCode: 998}, StateUnknown},
+		{"ocs unlisted", &OCSError{StatusCode: 404}, StateUnknown},
+		{"attempts follow first", &AttemptsError{Errs: []error{&AttemptError{Path: "/a", Err: &AuthError{StatusCode: 401}}, &AppNotEnabledError{App: "serverinfo"}}}, StateCritical},
+		{"wrapped", fmt.Errorf("instance: %w", &ResolveError{Host: "nc.invalid"}), StateUnknown},
+		{"untyped", errors.New("boom"), StateUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCodeForError(tt.err); got != tt.want {
+				t.Errorf("exitCodeForError(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GracePeriod is the number of consecutive runs a metric has to breach its
+// threshold before WARNING or CRITICAL is raised. 0 and 1 raise at once.
+type GracePeriod struct {
+	Warning  int
+	Critical int
+}
+
+// BreachCount counts the consecutive runs in which a metric breached its
+// WARNING and CRITICAL thresholds. A CRITICAL breach counts for both.
+type BreachCount struct {
+	Warning  int `json:"warning"`
+	Critical int `json:"critical,omitempty"`
+}
+
+// parseGracePeriod parses "N" for both severities or a list such as
+// "warning=3,critical=2". Severities not mentioned raise at once.
+func parseGracePeriod(spec string) (GracePeriod, error) {
+	var grace GracePeriod
+	if spec == "" {
+		return grace, nil
+	}
+	if n, err := strconv.Atoi(spec); err == nil {
+		if n < 0 {
+			return grace, fmt.Errorf("negative run count %d", n)
+		}
+		return GracePeriod{Warning: n, Critical: n}, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return grace, fmt.Errorf("expected N or severity=N, got %q", pair)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 0 {
+			return grace, fmt.Errorf("invalid run count %q", value)
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "warning":
+			grace.Warning = n
+		case "critical":
+			grace.Critical = n
+		default:
+			return grace, fmt.Errorf("unknown severity %q (supported: warning, critical)", name)
+		}
+	}
+	return grace, nil
+}
+
+// apply records a breach of metric at level in next, continuing the counts
+// from the previous run, and returns the state to report: level once its
+// grace period is reached, else WARNING when that grace period is reached,
+// else OK. count and required describe the progress towards level.
+func (g GracePeriod) apply(prev, next map[string]BreachCount, metric string, level int) (state, count, required int) {
+	breach := BreachCount{Warning: prev[metric].Warning + 1}
+	if level == StateCritical {
+		breach.Critical = prev[metric].Critical + 1
+	}
+	next[metric] = breach
+
+	if level == StateCritical && breach.Critical >= g.Critical {
+		return StateCritical, breach.Critical, g.Critical
+	}
+	if breach.Warning >= g.Warning {
+		return StateWarning, breach.Warning, g.Warning
+	}
+	if level == StateCritical {
+		return StateOK, breach.Critical, g.Critical
+	}
+	return StateOK, breach.Warning, g.Warning
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// verifyServerIdentity checks that resp was served by the expected host: by
+// the value of header when set, else by the SAN of the TLS certificate.
+// This catches DNS hijacks and load balancers routing to the wrong backend
+// that still present a certificate trusted for another name.
+func verifyServerIdentity(resp *http.Response, expected, header string) error {
+	if header != "" {
+		value := strings.TrimSpace(resp.Header.Get(header))
+		if value == "" {
+			return fmt.Errorf("no %s header", header)
+		}
+		if !strings.EqualFold(value, expected) {
+			return fmt.Errorf("%s header is %q", header, value)
+		}
+		return nil
+	}
+
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return fmt.Errorf("no TLS certificate to verify")
+	}
+	cert := resp.TLS.PeerCertificates[0]
+	if err := cert.VerifyHostname(expected); err != nil {
+		names := append([]string{}, cert.DNSNames...)
+		for _, ip := range cert.IPAddresses {
+			names = append(names, ip.String())
+		}
+		return fmt.Errorf("certificate is valid for %s", strings.Join(names, ", "))
+	}
+	return nil
+}
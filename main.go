@@ -1,186 +1,480 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
-type OCSResponse struct {
-	OCS struct {
-		Meta MetaInfo `json:"meta"`
-		Data DataInfo `json:"data"`
-	} `json:"ocs"`
+type Config struct {
+	ServerURL    string
+	Token        string
+	PerfdataFile string
+	NoPerfdata   bool
+	TalkHPBURL   string
+	MinVersion   string
+	// MinVersionCritical raises CRITICAL instead of WARNING when the
+	// installed version is below MinVersion.
+	MinVersionCritical bool
+	ExpectedEdition    string
+	UnixSocket         string
+	Format             string
+	// MemAvailable counts buffers and cache as available memory when
+	// serverinfo reports them.
+	MemAvailable bool
+	RequireSwap  bool
+	// OnlyMetrics reports OK regardless of thresholds so the plugin can be
+	// used purely for metric collection.
+	OnlyMetrics bool
+	// Mode restricts evaluation to a single check from the checks list.
+	// Empty or "all" evaluates every check.
+	Mode  string
+	Quiet bool
+	// CPULoadWarn holds the 1, 5 and 15 minute load averages above which
+	// the CPU check warns.
+	CPULoadWarn      [3]float64
+	CPUExpectedUsers int
+	Output           string
+	ProbeFirst       bool
+	// UserCap is the licensed or planned maximum number of users. When set,
+	// the user count is also evaluated as a percentage of the cap.
+	UserCap          int
+	UsersPercentWarn float64
+	UsersPercentCrit float64
+	// UnconfiguredChecks is either "skip" (no perfdata, not evaluated) or
+	// "metrics-only" (perfdata emitted, always OK) for checks without
+	// configured thresholds.
+	UnconfiguredChecks  string
+	CheckTrustedDomains bool
+	// Timeout bounds the whole check run including companion requests.
+	Timeout time.Duration
+	// Retries is the number of retries of transient serverinfo failures,
+	// RetryBackoff the base of their exponential backoff.
+	Retries      int
+	RetryBackoff time.Duration
+	// ConnectTimeout bounds establishing each connection, 0 leaves it to
+	// Timeout.
+	ConnectTimeout time.Duration
+	// PerfdataFields restricts perfdata to the listed metrics, nil means all.
+	PerfdataFields map[string]bool
+	// Precision is the number of perfdata decimal digits per metric type.
+	Precision map[string]int
+	// MaxSkew is the tolerated clock difference to the server, 0 disables
+	// the clock skew check.
+	MaxSkew time.Duration
+	// Metric prints only the raw value of the named metric and exits OK.
+	Metric string
+	// APIPaths are the serverinfo endpoints tried in order.
+	APIPaths []string
+	Debug    bool
+	// StateFile persists values between runs for checks that compare
+	// against previous results.
+	StateFile      string
+	CheckDowngrade bool
+	// MemoryGrowthWarn is the memory usage growth in percentage points per
+	// hour above which the memory_growth check warns, 0 disables it.
+	MemoryGrowthWarn float64
+	// SecurityScanMinGrade enables the Nextcloud security scanner check and
+	// warns below this grade.
+	SecurityScanMinGrade string
+	// SecurityScanMaxAge is the age after which the cached scanner grade is
+	// refreshed and a new scan is queued.
+	SecurityScanMaxAge time.Duration
+	// StatusPerfdata adds the final state as nagios_status metric.
+	StatusPerfdata bool
+	// BaselineFile holds the configuration facts compared on every run,
+	// WriteBaseline replaces them with the current ones.
+	BaselineFile  string
+	WriteBaseline bool
+	// StatusFallback reports the state from status.php when serverinfo
+	// cannot be queried.
+	StatusFallback bool
+	// CheckUpgrade queries status.php for a pending or stuck upgrade.
+	CheckUpgrade bool
+	// Strict reports impossible serverinfo values as UNKNOWN instead of
+	// clamping them.
+	Strict bool
+	// FailFast stops evaluating checks at the first CRITICAL.
+	FailFast bool
+	// SummaryOnly drops the version and details from the status line,
+	// leaving the status, breached condition and perfdata.
+	SummaryOnly bool
+	// Production marks the instance as production, where debug logging
+	// raises a warning. With ProductionAuto it is guessed from the URL.
+	Production Production
+	MaxLogSize int64
+	// AllowHTTP silences the plain HTTP warning, RequireHTTPS turns it into
+	// a CRITICAL.
+	AllowHTTP    bool
+	RequireHTTPS bool
+	// ScoreWeights weighs the components of the --output score health score.
+	ScoreWeights map[string]float64
+	// Tags are attached to the json, influx and prometheus outputs.
+	Tags Tags
+	// MetricPrefix namespaces the prometheus metric names and the influx
+	// measurement.
+	MetricPrefix string
+	// FilesWindow is the history used for the file growth rate, FilesLimit
+	// the file count it is projected against and FilesHorizonDays warns when
+	// the projection falls below that many days (0 disables).
+	FilesWindow      time.Duration
+	FilesLimit       int
+	FilesHorizonDays int
+	// ExternalStorageWarn and ExternalStorageCrit are the usage thresholds
+	// in percent for external mounts.
+	ExternalStorageWarn float64
+	ExternalStorageCrit float64
+	// ObjectStorageURL is probed for reachability and latency when set,
+	// ObjectStorageLatencyWarn warns above that latency (0 disables).
+	ObjectStorageURL         string
+	ObjectStorageLatencyWarn time.Duration
+	// Proxy is the forward proxy URL, ProxyUser and ProxyPassword
+	// authenticate to it.
+	Proxy         string
+	ProxyUser     string
+	ProxyPassword string
+	// HostHeader overrides the Host header (and TLS server name) of requests
+	// to the server while connecting to the ServerURL address.
+	HostHeader string
+	// CFClientID and CFClientSecret are sent as Cloudflare Access service
+	// token headers to the server.
+	CFClientID     string
+	CFClientSecret string
+	// ExpectedTimezone and ExpectedLocale warn when serverinfo reports a
+	// different server timezone or default locale.
+	ExpectedTimezone string
+	ExpectedLocale   string
+	// PrimaryHost restricts the full check to the cluster primary, other
+	// nodes are only probed for reachability.
+	PrimaryHost string
+	// DBSlowQueryPercentWarn warns when more than this percentage of the
+	// database queries are slow, 0 disables.
+	DBSlowQueryPercentWarn float64
+	// SharesPerUserWarn warns above this many shares per user, 0 disables.
+	SharesPerUserWarn float64
+	// AppUpdatesWarn is the number of pending app updates tolerated before
+	// warning, e.g. for an abandoned app that cannot be updated.
+	AppUpdatesWarn int
+	// OpcacheDisabledState is the state raised when the PHP opcache is
+	// reported as disabled.
+	OpcacheDisabledState int
+	// MaintenanceState is the state reported when the server answers with
+	// the X-Nextcloud-Maintenance-Mode header.
+	MaintenanceState int
+	// PrometheusFile receives the metrics in the Prometheus text format in
+	// addition to the regular output.
+	PrometheusFile string
+	// OpcacheOOMRestartsWarn warns above this many opcache out of memory
+	// restarts per hour, 0 disables.
+	OpcacheOOMRestartsWarn float64
+	// CheckProxyHeaders compares the URL Nextcloud generates against
+	// ExpectedBaseURL, which defaults to the server URL.
+	CheckProxyHeaders bool
+	ExpectedBaseURL   string
+	// CheckServerIdentity verifies the certificate SAN, or IdentityHeader
+	// when set, against ExpectedIdentity, which defaults to the HostHeader
+	// name or else the server host.
+	CheckServerIdentity bool
+	ExpectedIdentity    string
+	IdentityHeader      string
+	// CPUStealPercent attributes high CPU load to the hypervisor when at
+	// least this share of CPU time is stolen, 0 disables.
+	CPUStealPercent float64
+	// CPUStealState is the state raised for such load.
+	CPUStealState int
+	// BusinessHours is the daily window in which no active users in the
+	// last hour warns, nil disables.
+	BusinessHours *TimeWindow
+	// StoragesGrowthWarn warns when num_storages grew by more than this
+	// since the last run, 0 disables.
+	StoragesGrowthWarn int
+	// TimeoutRetry is the timeout of a single serverinfo retry after the
+	// first request ran into Timeout, 0 disables.
+	TimeoutRetry time.Duration
+	// OpcacheKeysPercentWarn warns when more than this percentage of the
+	// opcache keys is used, 0 disables.
+	OpcacheKeysPercentWarn float64
+	// OpcacheHitRateWarn and OpcacheHitRateCrit alert when the opcache hit
+	// rate falls below them, 0 disables either.
+	OpcacheHitRateWarn float64
+	OpcacheHitRateCrit float64
+	// GracePeriod delays alerts of fluctuating metrics until they breached
+	// in consecutive runs.
+	GracePeriod GracePeriod
+	// HTTP2Only requires HTTP/2 for requests to the server.
+	HTTP2Only bool
+	// FilesDropCrit is the drop of num_files in percent since the last run
+	// that raises CRITICAL, 0 disables.
+	FilesDropCrit float64
+	// CacheTTL reuses a serverinfo response of an earlier run younger than
+	// this, 0 disables.
+	CacheTTL time.Duration
+	// GraphitePrefix is the metric path prefix of --output graphite.
+	GraphitePrefix string
+	// PHPMemoryLimit overrides the memory_limit reported by serverinfo for
+	// the PHP memory fractions, -1 means unlimited, 0 uses serverinfo.
+	PHPMemoryLimit int64
+	// RequiredApps must be installed and enabled, checked through the
+	// provisioning API as AppsUser.
+	RequiredApps AppList
+	AppsUser     string
+	AppsPassword string
+	// AppCounts are app-specific counts emitted as app_<name> perfdata.
+	AppCounts AppCounts
+	// NormalizeVersion pads the version to four segments in the json,
+	// influx and prometheus output.
+	NormalizeVersion bool
 }
 
-type MetaInfo struct {
-	Status     string `json:"status"`
-	StatusCode int    `json:"statuscode"`
-	Message    string `json:"message"`
+// debugf writes a diagnostic line to stderr when --debug is set.
+func debugf(cfg Config, format string, args ...interface{}) {
+	if cfg.Debug {
+		fmt.Fprintf(os.Stderr, "DEBUG: "+format+"\n", args...)
+	}
 }
 
-type DataInfo struct {
-	Nextcloud   NextcloudInfo   `json:"nextcloud"`
-	Server      ServerInfo      `json:"server"`
-	ActiveUsers ActiveUsersInfo `json:"activeUsers"`
+// checkEnabled reports whether the named check is evaluated in this run.
+func (cfg Config) checkEnabled(name string) bool {
+	return cfg.Mode == "" || cfg.Mode == "all" || cfg.Mode == name
 }
 
-type NextcloudInfo struct {
-	System  NextcloudSystem  `json:"system"`
-	Storage NextcloudStorage `json:"storage"`
-	Shares  NextcloudShares  `json:"shares"`
+// checkNextcloud fetches serverinfo from cfg.ServerURL and evaluates all
+// checks. It returns the plugin output line and exit code, or an error when
+// the instance could not be queried.
+func checkNextcloud(ctx context.Context, cfg Config) (string, int, error) {
+	output, exitCode, _, err := runCheck(ctx, cfg)
+	return output, exitCode, err
 }
 
-type NextcloudSystem struct {
-	Version   string        `json:"version"`
-	Cpuload   []float64     `json:"cpuload"`
-	MemTotal  int64         `json:"mem_total"`
-	MemFree   int64         `json:"mem_free"`
-	SwapTotal int64         `json:"swap_total"`
-	SwapFree  int64         `json:"swap_free"`
-	Apps      NextcloudApps `json:"apps"`
-	Update    UpdateInfo    `json:"update"`
-}
+// runCheck implements checkNextcloud and additionally returns the
+// HealthSummary of the run, which --webhook-url posts. The summary is nil
+// when no checks were evaluated, e.g. with --mode status or --metric.
+func runCheck(ctx context.Context, cfg Config) (string, int, *HealthSummary, error) {
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
 
-type NextcloudApps struct {
-	NumInstalled        int `json:"num_installed"`
-	NumUpdatesAvailable int `json:"num_updates_available"`
-}
+	// Plain HTTP sends the NC-Token in cleartext. This is decided before any
+	// request is made; connections through a local unix socket are exempt.
+	plainHTTP := false
+	if u, err := url.Parse(cfg.ServerURL); err == nil && u.Scheme == "http" {
+		plainHTTP = cfg.checkEnabled("https") && !cfg.AllowHTTP && cfg.UnixSocket == ""
+	}
+	// The token went out in cleartext even when the run fails, so the
+	// warning is appended to the error as well.
+	withPlainHTTP := func(err error) error {
+		if !plainHTTP || err == nil {
+			return err
+		}
+		return fmt.Errorf("%w (Server URL Uses Plain HTTP)", err)
+	}
 
-type NextcloudStorage struct {
-	NumUsers int `json:"num_users"`
-	NumFiles int `json:"num_files"`
-}
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return "", 0, nil, err
+	}
 
-type NextcloudShares struct {
-	NumShares int `json:"num_shares"`
-}
+	if cfg.ProbeFirst {
+		if err := probeInstance(ctx, client, cfg.ServerURL); err != nil {
+			return "", 0, nil, withPlainHTTP(&UnreachableError{Err: err})
+		}
+	}
 
-type ServerInfo struct {
-	PHP      PHPInfo      `json:"php"`
-	Database DatabaseInfo `json:"database"`
-}
+	// Secondary cluster nodes share the database with the primary, so their
+	// serverinfo only duplicates it. Only their reachability is checked.
+	if cfg.PrimaryHost != "" && !isPrimaryNode(cfg.ServerURL, cfg.PrimaryHost) {
+		if err := probeInstance(ctx, client, cfg.ServerURL); err != nil {
+			return "", 0, nil, withPlainHTTP(&UnreachableError{Err: err})
+		}
+		return "OK - " + serverHost(cfg.ServerURL) + " reachable, checks skipped on non-primary node (primary: " + cfg.PrimaryHost + ")", 0, nil, nil
+	}
 
-type PHPInfo struct {
-	Version string         `json:"version"`
-	Opcache PHPOpcacheInfo `json:"opcache"`
-}
+	if cfg.Mode == "status" {
+		output, exitCode, err := checkStatus(ctx, client, cfg)
+		return output, exitCode, nil, withPlainHTTP(err)
+	}
 
-type PHPOpcacheInfo struct {
-	OpcacheStatistics OpcacheStatisticsInfo `json:"opcache_statistics"`
-}
+	// With --cache-ttl a recent response of an earlier run is reused, e.g.
+	// when one service per mode checks the same instance. The cached
+	// response has no Date header, so the clock skew check is skipped.
+	// The status.php response of the upgrade check is cached along with it.
+	var ocsResp *OCSResponse
+	var resp *http.Response
+	var instanceStatus *StatusInfo
+	var instanceStatusErr error
+	checkUpgrade := cfg.CheckUpgrade || cfg.Mode == "upgrade"
+	fromCache := false
+	if cfg.CacheTTL > 0 {
+		if cached, age := loadCache(cachePath(cfg), cfg.CacheTTL, time.Now()); cached != nil {
+			debugf(cfg, "using serverinfo cached %s ago", age.Round(time.Second))
+			ocsResp, resp, fromCache = cached.Response, &http.Response{Header: http.Header{}}, true
+			instanceStatus = cached.Status
+		}
+	}
 
-type OpcacheStatisticsInfo struct {
-	OpcacheHitRate float64 `json:"opcache_hit_rate"`
-}
+	timeoutNote := ""
+	if ocsResp == nil {
+		ocsResp, resp, err = fetchServerInfoRetry(ctx, client, cfg)
 
-type DatabaseInfo struct {
-	Version string `json:"version"`
-}
+		// A slow serverinfo is retried once with --timeout-retry. The rest
+		// of the run then uses the longer timeout as well.
+		if err != nil && cfg.TimeoutRetry > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			debugf(cfg, "retrying with a timeout of %s after: %v", cfg.TimeoutRetry, err)
+			retryCtx, retryCancel := context.WithTimeout(parent, cfg.TimeoutRetry)
+			defer retryCancel()
+			ctx = retryCtx
+			start := time.Now()
+			ocsResp, resp, err = fetchServerInfo(ctx, client, cfg)
+			if err == nil {
+				timeoutNote = fmt.Sprintf(" Slow response: serverinfo timed out after %s and answered on the retry in %s.", cfg.Timeout, time.Since(start).Round(time.Millisecond))
+			}
+		}
+		var maintenanceErr *MaintenanceError
+		if err != nil {
+			if cfg.StatusFallback && ctx.Err() == nil && !errors.As(err, &maintenanceErr) {
+				if result, exitCode, ok := statusFallback(ctx, client, cfg, err); ok {
+					return result, exitCode, nil, nil
+				}
+			}
+			if cfg.ProbeFirst {
+				return "", 0, nil, withPlainHTTP(&EndpointError{Err: err})
+			}
+			return "", 0, nil, withPlainHTTP(err)
+		}
 
-type ActiveUsersInfo struct {
-	Last5minutes int `json:"last5minutes"`
-	Last1hour    int `json:"last1hour"`
-	Last24hours  int `json:"last24hours"`
-	Last7days    int `json:"last7days"`
-	Last1month   int `json:"last1month"`
-	Last3months  int `json:"last3months"`
-	Last6months  int `json:"last6months"`
-	Lastyear     int `json:"lastyear"`
-}
+		debugf(cfg, "serverinfo answered over %s", resp.Proto)
+		if checkUpgrade {
+			instanceStatus, instanceStatusErr = fetchStatus(ctx, client, cfg.ServerURL)
+		}
+		if cfg.CacheTTL > 0 {
+			saveCache(cachePath(cfg), ocsResp, instanceStatus, time.Now())
+		}
+	} else if checkUpgrade && instanceStatus == nil {
+		// The entry was cached by a run without --check-upgrade.
+		instanceStatus, instanceStatusErr = fetchStatus(ctx, client, cfg.ServerURL)
+	}
 
-type UpdateInfo struct {
-	LastUpdatedAt    int64  `json:"lastupdatedat"`
-	Available        bool   `json:"available"`
-	AvailableVersion string `json:"available_version"`
-}
+	if err := sanitizeServerInfo(cfg, &ocsResp.OCS.Data); err != nil {
+		return "", 0, nil, withPlainHTTP(err)
+	}
 
-func checkNextcloud(serverURL string, ncToken string) {
-	apiURL := fmt.Sprintf("%s/ocs/v2.php/apps/serverinfo/api/v1/info?format=json&skipApps=false&skipUpdate=false", serverURL)
+	status := "OK"
+	exitCode := 0
 
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+	// alert raises the state to level with message and records breach for
+	// the json document, so its breaches agree with the reported state.
+	// The value of a breached metric is filled in from the metrics.
+	var breaches []Breach
+	alert := func(level int, message string, breach Breach) {
+		status = stateNames[level] + " - " + message
+		if exitCode < level {
+			exitCode = level
+		}
+		breach.Severity = stateNames[level]
+		breach.Message = message
+		breaches = append(breaches, breach)
 	}
 
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
-		fmt.Printf("CRITICAL - Failed to create request: %v\n", err)
-		os.Exit(2)
+	if plainHTTP {
+		if cfg.RequireHTTPS {
+			alert(StateCritical, "Server URL Uses Plain HTTP", Breach{Metric: "https"})
+		} else {
+			alert(StateWarning, "Server URL Uses Plain HTTP", Breach{Metric: "https"})
+		}
 	}
-	req.Header.Set("NC-Token", ncToken)
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("User-Agent", "check_nextcloud/1.0")
 
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("CRITICAL - API request failed: %v\n", err)
-		os.Exit(2)
+	// With --fail-fast the remaining checks are skipped once one is
+	// CRITICAL, so the status reports only that first condition.
+	evaluate := func(name string) bool {
+		return cfg.checkEnabled(name) && !(cfg.FailFast && exitCode >= 2)
 	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			fmt.Printf("CRITICAL - Failed to close response body: %v\n", err)
-			os.Exit(2)
-		}
-	}(resp.Body)
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		fmt.Println("CRITICAL - Unauthorized access (401)")
-		os.Exit(2)
-	}
+	sysInfo := ocsResp.OCS.Data.Nextcloud.System
+	details := timeoutNote
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("CRITICAL - Failed to read API response: %v\n", err)
-		os.Exit(2)
+	var prevState *State
+	state := &State{Timestamp: time.Now().Unix(), Version: sysInfo.Version}
+	if cfg.StateFile != "" {
+		prevState = loadState(cfg.StateFile)
 	}
 
-	var ocsResp OCSResponse
-	err = json.Unmarshal(body, &ocsResp)
-	if err != nil {
-		fmt.Printf("CRITICAL - Failed to parse API response: %v\n", err)
-		os.Exit(2)
+	// raise reports a breached threshold of a fluctuating metric, counted
+	// under key. With --grace-period it only escalates after the configured
+	// number of consecutive breaching runs and is noted in the details
+	// until then, without being listed as a breach.
+	var prevBreaches map[string]BreachCount
+	if prevState != nil {
+		prevBreaches = prevState.Breaches
 	}
-
-	if ocsResp.OCS.Data.Nextcloud.System.Version == "" {
-		fmt.Println("CRITICAL - Invalid API response")
-		os.Exit(2)
+	state.Breaches = map[string]BreachCount{}
+	raise := func(key string, level int, message string, breach Breach) {
+		reported, count, required := cfg.GracePeriod.apply(prevBreaches, state.Breaches, key, level)
+		if reported == StateOK {
+			details += fmt.Sprintf(" %s (%s %d/%d runs, grace period).", message, stateNames[level], count, required)
+			return
+		}
+		alert(reported, message, breach)
 	}
 
-	status := "OK"
-	exitCode := 0
-
-	sysInfo := ocsResp.OCS.Data.Nextcloud.System
-
-	if len(sysInfo.Cpuload) >= 3 {
-		if sysInfo.Cpuload[0] > 5 || sysInfo.Cpuload[1] > 4 || sysInfo.Cpuload[2] > 3 {
-			status = "WARNING - High CPU Load"
-			if exitCode < 1 {
-				exitCode = 1
+	// With --cpu-expected-users the CPU check becomes a composite: high load
+	// while at least that many users were active in the last 5 minutes is
+	// treated as legitimate and only load without matching activity warns.
+	if evaluate("cpu_load") && len(sysInfo.Cpuload) >= 3 {
+		load := cfg.CPULoadWarn
+		var loadBreach Breach
+		for i, metric := range []string{"cpu_load_1m", "cpu_load_5m", "cpu_load_15m"} {
+			if sysInfo.Cpuload[i] > load[i] {
+				loadBreach = Breach{Metric: metric, Threshold: formatThreshold(load[i])}
+				break
 			}
 		}
+		highLoad := loadBreach.Metric != ""
+		busy := cfg.CPUExpectedUsers > 0 && ocsResp.OCS.Data.ActiveUsers.Last5minutes >= cfg.CPUExpectedUsers
+		// On virtual machines load caused by a noisy neighbor shows up as
+		// steal time, which is attributed to the hypervisor instead.
+		stolen := sysInfo.CPUSteal != nil && cfg.CPUStealPercent > 0 && *sysInfo.CPUSteal >= cfg.CPUStealPercent
+		switch {
+		case highLoad && !busy && stolen:
+			message := fmt.Sprintf("High CPU Steal %.1f%% (Hypervisor Contention)", *sysInfo.CPUSteal)
+			if cfg.CPUStealState == StateOK {
+				details += " " + message + "."
+			} else {
+				alert(cfg.CPUStealState, message, Breach{Metric: "cpu_steal_percent", Threshold: formatThreshold(cfg.CPUStealPercent)})
+			}
+		case highLoad && !busy:
+			message := "High CPU Load"
+			if sysInfo.CPUIowait != nil {
+				message += fmt.Sprintf(" (IO Wait %.1f%%)", *sysInfo.CPUIowait)
+			}
+			raise("cpu_load", StateWarning, message, loadBreach)
+		}
 	}
 
 	memTotal := sysInfo.MemTotal
 	memFree := sysInfo.MemFree
+	memAvailable := memFree
+	if cfg.MemAvailable && sysInfo.MemBuffers != nil && sysInfo.MemCached != nil {
+		memAvailable += *sysInfo.MemBuffers + *sysInfo.MemCached
+	}
 	memUsage := 0.0
 	if memTotal > 0 {
-		memUsage = (float64(memTotal-memFree) / float64(memTotal)) * 100
+		memUsage = (float64(memTotal-memAvailable) / float64(memTotal)) * 100
 	}
-	if memUsage > 90 {
-		status = "CRITICAL - High Memory Usage"
-		if exitCode < 2 {
-			exitCode = 2
-		}
-	} else if memUsage > 80 {
-		status = "WARNING - High Memory Usage"
-		if exitCode < 1 {
-			exitCode = 1
+	if evaluate("memory") {
+		if memUsage > memoryCritPercent {
+			raise("memory_usage_percent", StateCritical, "High Memory Usage", Breach{Metric: "memory_usage_percent", Threshold: formatThreshold(memoryCritPercent)})
+		} else if memUsage > memoryWarnPercent {
+			raise("memory_usage_percent", StateWarning, "High Memory Usage", Breach{Metric: "memory_usage_percent", Threshold: formatThreshold(memoryWarnPercent)})
 		}
 	}
 
@@ -190,38 +484,491 @@ func checkNextcloud(serverURL string, ncToken string) {
 	if swapTotal > 0 {
 		swapUsage = (float64(swapTotal-swapFree) / float64(swapTotal)) * 100
 	}
-	if swapUsage > 90 {
-		status = "CRITICAL - High Swap Usage"
-		if exitCode < 2 {
-			exitCode = 2
+	if evaluate("swap") {
+		if swapUsage > swapCritPercent {
+			raise("swap_usage_percent", StateCritical, "High Swap Usage", Breach{Metric: "swap_usage_percent", Threshold: formatThreshold(swapCritPercent)})
+		} else if swapUsage > swapWarnPercent {
+			raise("swap_usage_percent", StateWarning, "High Swap Usage", Breach{Metric: "swap_usage_percent", Threshold: formatThreshold(swapWarnPercent)})
+		}
+	}
+
+	if evaluate("swap_configured") && cfg.RequireSwap && swapTotal == 0 {
+		alert(StateWarning, "No Swap Configured", Breach{Metric: "swap_configured"})
+	}
+
+	if evaluate("app_updates") && sysInfo.Apps.NumUpdatesAvailable > cfg.AppUpdatesWarn {
+		alert(StateWarning, "App Updates Available", Breach{Metric: "num_apps_update_available", Threshold: strconv.Itoa(cfg.AppUpdatesWarn)})
+	}
+
+	if evaluate("nextcloud_update") && sysInfo.Update.Available {
+		alert(StateWarning, "Nextcloud Update Available ("+sysInfo.Update.AvailableVersion+")", Breach{Metric: "nextcloud_update"})
+	}
+
+	if readOnly := ocsResp.OCS.Data.Nextcloud.Storage.ReadOnly; evaluate("data_readonly") && readOnly != nil && *readOnly {
+		alert(StateCritical, "Data Directory Read-Only", Breach{Metric: "data_readonly"})
+	}
+
+	// An interrupted upgrade leaves the code updated while the database is
+	// not, serverinfo may still answer but the instance is unusable.
+	// status.php reports this independently of maintenance mode.
+	if evaluate("upgrade") && checkUpgrade {
+		if instanceStatusErr != nil {
+			details += fmt.Sprintf(" Upgrade state unknown, status.php failed: %v.", instanceStatusErr)
+		} else if instanceStatus.NeedsDbUpgrade {
+			alert(StateCritical, "Upgrade Pending Or Stuck (database needs upgrade)", Breach{Metric: "upgrade"})
+		}
+	}
+
+	if evaluate("min_version") && cfg.MinVersion != "" {
+		cmp, err := compareVersions(sysInfo.Version, cfg.MinVersion)
+		if err != nil {
+			return "", 0, nil, withPlainHTTP(&ParseError{Op: "Failed to compare versions", Err: err})
+		}
+		if cmp < 0 {
+			if cfg.MinVersionCritical {
+				alert(StateCritical, "Nextcloud "+sysInfo.Version+" below minimum version "+cfg.MinVersion, Breach{Metric: "min_version", Threshold: cfg.MinVersion})
+			} else {
+				alert(StateWarning, "Nextcloud "+sysInfo.Version+" below minimum version "+cfg.MinVersion, Breach{Metric: "min_version", Threshold: cfg.MinVersion})
+			}
+		}
+	}
+
+	if evaluate("edition") && cfg.ExpectedEdition != "" && !strings.EqualFold(sysInfo.Edition, cfg.ExpectedEdition) {
+		edition := sysInfo.Edition
+		if edition == "" {
+			edition = "unknown"
+		}
+		alert(StateWarning, "Nextcloud Edition Mismatch (expected "+cfg.ExpectedEdition+", got "+edition+")", Breach{Metric: "edition", Threshold: cfg.ExpectedEdition})
+	}
+
+	if prevState != nil && prevState.Version != "" {
+		cmp, err := compareVersions(sysInfo.Version, prevState.Version)
+		if err != nil {
+			return "", 0, nil, withPlainHTTP(&ParseError{Op: "Failed to compare versions", Err: err})
+		}
+		if cmp < 0 {
+			state.Version = prevState.Version
+			if evaluate("version_regression") && cfg.CheckDowngrade {
+				alert(StateCritical, "Nextcloud Version Regressed ("+prevState.Version+" -> "+sysInfo.Version+")", Breach{Metric: "version_regression", Threshold: prevState.Version})
+			}
+		}
+	}
+
+	// The memory growth rate is expressed in percentage points per hour so
+	// it does not depend on the check interval.
+	memDelta, hasMemDelta := 0.0, false
+	state.MemoryUsagePercent = &memUsage
+	if prevState != nil && prevState.MemoryUsagePercent != nil && state.Timestamp > prevState.Timestamp {
+		memDelta, hasMemDelta = memUsage-*prevState.MemoryUsagePercent, true
+		hours := float64(state.Timestamp-prevState.Timestamp) / 3600
+		if evaluate("memory_growth") && cfg.MemoryGrowthWarn > 0 && memDelta/hours > cfg.MemoryGrowthWarn {
+			alert(StateWarning, fmt.Sprintf("Memory Usage Growing %.1f%%/h", memDelta/hours), Breach{Metric: "memory_growth", Value: math.Round(memDelta/hours*100) / 100, Threshold: formatThreshold(cfg.MemoryGrowthWarn)})
+		}
+	}
+
+	// File growth is measured against the oldest sample within the window.
+	// With less than a day of history the rate is too noisy to project.
+	numFiles := ocsResp.OCS.Data.Nextcloud.Storage.NumFiles
+	filesPerDay, hasFilesPerDay := 0.0, false
+
+	// A sudden drop is a data loss tripwire: mass deletion or a storage that
+	// failed to mount and exposes an empty directory. The first run has no
+	// previous count and is skipped.
+	filesChange, hasFilesChange := 0.0, false
+	state.NumFiles = &numFiles
+	if prevState != nil && prevState.NumFiles != nil && *prevState.NumFiles > 0 {
+		filesChange, hasFilesChange = float64(numFiles-*prevState.NumFiles)/float64(*prevState.NumFiles)*100, true
+		if evaluate("files_drop") && cfg.FilesDropCrit > 0 && -filesChange > cfg.FilesDropCrit {
+			alert(StateCritical, fmt.Sprintf("File Count Dropped %.1f%% Since Last Run (%d -> %d)", -filesChange, *prevState.NumFiles, numFiles), Breach{Metric: "num_files_change_percent", Threshold: formatThreshold(-cfg.FilesDropCrit) + ":"})
+		}
+	}
+	daysUntilLimit, hasDaysUntilLimit := 0.0, false
+	if cfg.StateFile != "" {
+		var prevSamples []FileSample
+		if prevState != nil {
+			prevSamples = prevState.FileSamples
+		}
+		state.FileSamples = recordFileSample(prevSamples, FileSample{Timestamp: state.Timestamp, NumFiles: numFiles}, int64(cfg.FilesWindow.Seconds()))
+		if baseline := state.FileSamples[0]; state.Timestamp-baseline.Timestamp >= 86400 {
+			days := float64(state.Timestamp-baseline.Timestamp) / 86400
+			filesPerDay, hasFilesPerDay = float64(numFiles-baseline.NumFiles)/days, true
+		}
+	}
+	if hasFilesPerDay && cfg.FilesLimit > 0 {
+		if numFiles >= cfg.FilesLimit {
+			daysUntilLimit, hasDaysUntilLimit = 0, true
+		} else if filesPerDay > 0 {
+			daysUntilLimit, hasDaysUntilLimit = float64(cfg.FilesLimit-numFiles)/filesPerDay, true
+		}
+		if evaluate("files_growth") && hasDaysUntilLimit && cfg.FilesHorizonDays > 0 && daysUntilLimit < float64(cfg.FilesHorizonDays) {
+			alert(StateWarning, fmt.Sprintf("File Limit Reached In %.0f Days", daysUntilLimit), Breach{Metric: "num_files_days_until_limit", Threshold: formatThreshold(float64(cfg.FilesHorizonDays)) + ":"})
+		}
+	}
+
+	// The opcache counts OOM restarts since PHP started, so the rate is taken
+	// between runs. A lower count means PHP was restarted in between.
+	opcacheStats := ocsResp.OCS.Data.Server.PHP.Opcache.OpcacheStatistics
+	oomRestartRate, hasOOMRestartRate := 0.0, false
+	state.OpcacheOOMRestarts = opcacheStats.OOMRestarts
+	if prevState != nil && prevState.OpcacheOOMRestarts != nil && opcacheStats.OOMRestarts != nil &&
+		state.Timestamp > prevState.Timestamp && *opcacheStats.OOMRestarts >= *prevState.OpcacheOOMRestarts {
+		hours := float64(state.Timestamp-prevState.Timestamp) / 3600
+		oomRestartRate, hasOOMRestartRate = float64(*opcacheStats.OOMRestarts-*prevState.OpcacheOOMRestarts)/hours, true
+		if evaluate("opcache_restarts") && cfg.OpcacheOOMRestartsWarn > 0 && oomRestartRate > cfg.OpcacheOOMRestartsWarn {
+			alert(StateWarning, fmt.Sprintf("PHP Opcache Out Of Memory Restarts %.2f/h", oomRestartRate), Breach{Metric: "opcache_oom_restart_rate", Threshold: formatThreshold(cfg.OpcacheOOMRestartsWarn)})
+		}
+	}
+
+	// A burst of new storages can be a compromised account mass-mounting
+	// shares. The first run has no previous count and is skipped.
+	numStorages := ocsResp.OCS.Data.Nextcloud.Storage.NumStorages
+	storagesDelta, hasStoragesDelta := 0, false
+	state.NumStorages = &numStorages
+	if prevState != nil && prevState.NumStorages != nil {
+		storagesDelta, hasStoragesDelta = numStorages-*prevState.NumStorages, true
+		if evaluate("storages_growth") && cfg.StoragesGrowthWarn > 0 && storagesDelta > cfg.StoragesGrowthWarn {
+			alert(StateWarning, fmt.Sprintf("Number Of Storages Grew By %d Since Last Run", storagesDelta), Breach{Metric: "num_storages_delta", Threshold: strconv.Itoa(cfg.StoragesGrowthWarn)})
+		}
+	}
+
+	// Zero activity is expected at night but points to an outage the
+	// users work around (e.g. a broken login) during business hours.
+	if evaluate("active_users") && cfg.BusinessHours != nil && cfg.BusinessHours.contains(time.Now()) && ocsResp.OCS.Data.ActiveUsers.Last1hour == 0 {
+		alert(StateWarning, "No Active Users During Business Hours", Breach{Metric: "active_users_1h", Threshold: "1:"})
+	}
+
+	// The baseline is recorded on the first run or with --write-baseline,
+	// later runs warn on every fact that changed since.
+	if cfg.BaselineFile != "" {
+		facts := collectFacts(ocsResp.OCS.Data)
+		baseline, err := loadBaseline(cfg.BaselineFile)
+		if err != nil {
+			return "", 0, nil, withPlainHTTP(&ParseError{Op: "Failed to read baseline file", Err: err})
+		}
+		if baseline == nil || cfg.WriteBaseline {
+			saveBaseline(cfg.BaselineFile, facts)
+			details += " Configuration baseline recorded."
+		} else if drift := baselineDrift(baseline, facts); evaluate("baseline") && len(drift) > 0 {
+			alert(StateWarning, "Configuration Drift ("+strings.Join(drift, ", ")+")", Breach{Metric: "baseline"})
+		}
+	}
+	if resp.Request != nil && resp.Request.URL.RawQuery == xmlFallbackQuery {
+		details += " Serverinfo answered with JSON only on the ?" + xmlFallbackQuery + " retry, check the query string handling of proxies."
+	}
+	if evaluate("trusted_domains") && cfg.CheckTrustedDomains {
+		if sysInfo.TrustedDomains == nil {
+			details += " Trusted domains not reported by serverinfo."
+		} else if !hostTrusted(cfg.ServerURL, sysInfo.TrustedDomains) {
+			alert(StateWarning, serverHost(cfg.ServerURL)+" Not In Trusted Domains", Breach{Metric: "trusted_domains"})
+		}
+	}
+	// Wrong overwriteprotocol or trusted_proxies settings make Nextcloud
+	// generate links with the wrong scheme or host, which breaks logins and
+	// clients in confusing ways.
+	if evaluate("reverse_proxy") && cfg.CheckProxyHeaders {
+		expected, err := expectedBaseURL(cfg)
+		if err != nil {
+			return "", 0, nil, withPlainHTTP(&ParseError{Op: "Invalid expected base URL", Err: err})
+		}
+		generated, err := fetchGeneratedURL(ctx, client, cfg.ServerURL)
+		if err != nil {
+			details += fmt.Sprintf(" Generated URL not verifiable: %v.", err)
+		} else if !sameOrigin(expected, generated) {
+			alert(StateWarning, "Reverse Proxy Mismatch (expected "+expected.Scheme+"://"+expected.Host+", got "+generated.Scheme+"://"+generated.Host+")", Breach{Metric: "reverse_proxy", Threshold: expected.Scheme + "://" + expected.Host})
+		}
+	}
+	// A cached response carries neither headers nor the TLS state, so the
+	// identity is only verified on a fresh response.
+	if evaluate("server_identity") && cfg.CheckServerIdentity && !fromCache {
+		expected := cfg.ExpectedIdentity
+		if expected == "" && cfg.HostHeader != "" {
+			expected = hostWithoutPort(cfg.HostHeader)
+		} else if expected == "" {
+			expected = hostWithoutPort(serverHost(cfg.ServerURL))
+		}
+		if err := verifyServerIdentity(resp, expected, cfg.IdentityHeader); err != nil {
+			alert(StateWarning, "Server Identity Mismatch (expected "+expected+")", Breach{Metric: "server_identity", Threshold: expected})
+			details += fmt.Sprintf(" Server identity: %v.", err)
+		}
+	}
+	// A server timezone or locale differing from the expected one is
+	// configuration drift rather than an outage, so it only warns.
+	if evaluate("locale") {
+		if sysInfo.Timezone != nil {
+			details += " Timezone " + *sysInfo.Timezone + "."
+			if cfg.ExpectedTimezone != "" && !strings.EqualFold(*sysInfo.Timezone, cfg.ExpectedTimezone) {
+				alert(StateWarning, "Timezone Mismatch (expected "+cfg.ExpectedTimezone+", got "+*sysInfo.Timezone+")", Breach{Metric: "locale", Threshold: cfg.ExpectedTimezone})
+			}
+		}
+		if sysInfo.DefaultLocale != nil {
+			details += " Default locale " + *sysInfo.DefaultLocale + "."
+			if cfg.ExpectedLocale != "" && !strings.EqualFold(*sysInfo.DefaultLocale, cfg.ExpectedLocale) {
+				alert(StateWarning, "Locale Mismatch (expected "+cfg.ExpectedLocale+", got "+*sysInfo.DefaultLocale+")", Breach{Metric: "locale", Threshold: cfg.ExpectedLocale})
+			}
+		}
+	}
+	if evaluate("talk_hpb") && cfg.TalkHPBURL != "" {
+		hpbVersion, err := checkTalkHPB(ctx, client, cfg.TalkHPBURL)
+		if err != nil {
+			alert(StateCritical, "Talk HPB Unreachable", Breach{Metric: "talk_hpb"})
+			details += fmt.Sprintf(" Talk HPB check failed: %v.", err)
+		} else {
+			details += fmt.Sprintf(" Talk HPB %s running.", hpbVersion)
+		}
+	}
+
+	// Only queried when apps are required, the provisioning API is an extra
+	// request with separate credentials.
+	if evaluate("required_apps") && len(cfg.RequiredApps) > 0 {
+		enabledApps, err := fetchEnabledApps(ctx, client, cfg)
+		if err != nil {
+			alert(StateCritical, "App Check Failed", Breach{Metric: "required_apps"})
+			details += fmt.Sprintf(" App check failed: %v.", err)
+		} else {
+			var missing []string
+			for _, id := range cfg.RequiredApps {
+				if !enabledApps[id] {
+					missing = append(missing, id)
+				}
+			}
+			if len(missing) > 0 {
+				alert(StateCritical, "Required App Missing ("+strings.Join(missing, ", ")+")", Breach{Metric: "required_apps"})
+			} else {
+				details += " Required apps enabled: " + strings.Join(cfg.RequiredApps, ", ") + "."
+			}
+		}
+	}
+
+	// App counts are informational, an unavailable endpoint only skips its
+	// metric.
+	appCountValues := map[string]int{}
+	if evaluate("app_counts") {
+		for _, count := range cfg.AppCounts {
+			value, err := fetchAppCount(ctx, client, cfg, count)
+			if err != nil {
+				debugf(cfg, "app count %s failed: %v", count.Name, err)
+				details += fmt.Sprintf(" App count %s unavailable: %v.", count.Name, err)
+				continue
+			}
+			appCountValues[count.Name] = value
+		}
+	}
+
+	// Object storage as primary storage fails differently from a local data
+	// directory, so report the backend and optionally probe the bucket.
+	if evaluate("object_storage") {
+		if backend := ocsResp.OCS.Data.Nextcloud.Storage.PrimaryStorage; backend != nil && *backend != "" && *backend != "local" {
+			details += fmt.Sprintf(" Primary storage is object storage (%s).", *backend)
+		}
+	}
+	// External mounts are not covered by the free space of the data
+	// directory. The fullest mount is reported as perfdata and every mount
+	// above a threshold is named in the status.
+	externalUsage, hasExternalUsage := 0.0, false
+	if evaluate("external_storage") {
+		var warnMounts, critMounts []string
+		for _, mount := range ocsResp.OCS.Data.Nextcloud.Storage.ExternalStorages {
+			if mount.Total <= 0 {
+				continue
+			}
+			usage := float64(mount.Total-mount.Free) / float64(mount.Total) * 100
+			if !hasExternalUsage || usage > externalUsage {
+				externalUsage, hasExternalUsage = usage, true
+			}
+			if usage > cfg.ExternalStorageCrit {
+				critMounts = append(critMounts, mount.MountPoint)
+			} else if usage > cfg.ExternalStorageWarn {
+				warnMounts = append(warnMounts, mount.MountPoint)
+			}
+		}
+		if len(critMounts) > 0 {
+			alert(StateCritical, "External Storage Nearly Full ("+strings.Join(critMounts, ", ")+")", Breach{Metric: "external_storage_usage_percent", Threshold: formatThreshold(cfg.ExternalStorageCrit)})
+		} else if len(warnMounts) > 0 {
+			alert(StateWarning, "External Storage Nearly Full ("+strings.Join(warnMounts, ", ")+")", Breach{Metric: "external_storage_usage_percent", Threshold: formatThreshold(cfg.ExternalStorageWarn)})
+		}
+	}
+
+	objectStorageLatency, hasObjectStorageLatency := time.Duration(0), false
+	if evaluate("object_storage") && cfg.ObjectStorageURL != "" {
+		latency, err := probeObjectStorage(ctx, client, cfg.ObjectStorageURL)
+		if err != nil {
+			alert(StateCritical, "Object Storage Unreachable", Breach{Metric: "object_storage"})
+			details += fmt.Sprintf(" Object storage check failed: %v.", err)
+		} else {
+			objectStorageLatency, hasObjectStorageLatency = latency, true
+			details += fmt.Sprintf(" Object storage answered in %dms.", latency.Milliseconds())
+			if cfg.ObjectStorageLatencyWarn > 0 && latency > cfg.ObjectStorageLatencyWarn {
+				raise("object_storage_latency_ms", StateWarning, "Object Storage Slow", Breach{Metric: "object_storage_latency_ms", Threshold: formatThreshold(float64(cfg.ObjectStorageLatencyWarn.Milliseconds()))})
+			}
+		}
+	}
+
+	// Clock skew is positive when the server clock is ahead of ours. The
+	// Date header only has second resolution.
+	skew, hasSkew := 0.0, false
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		skew, hasSkew = date.Sub(time.Now()).Seconds(), true
+		if evaluate("clock_skew") && cfg.MaxSkew > 0 && math.Abs(skew) > cfg.MaxSkew.Seconds() {
+			maxSkew := formatThreshold(cfg.MaxSkew.Seconds())
+			raise("clock_skew_seconds", StateWarning, fmt.Sprintf("Clock Skew %.0fs", skew), Breach{Metric: "clock_skew_seconds", Threshold: "-" + maxSkew + ":" + maxSkew})
+		}
+	}
+
+	// The scanner is an external service, so failing to reach it is UNKNOWN
+	// rather than a problem of the instance. The grade is cached in the
+	// state file and only refreshed after --security-scan-max-age.
+	if prevState != nil {
+		state.SecurityScan = prevState.SecurityScan
+	}
+	if evaluate("security_scan") && cfg.SecurityScanMinGrade != "" {
+		scan, err := fetchSecurityGrade(ctx, client, cfg.ServerURL, state.SecurityScan, cfg.SecurityScanMaxAge, time.Now())
+		if err != nil {
+			details += fmt.Sprintf(" Security scan failed: %v.", err)
+			alert(StateUnknown, "Security Scan Unavailable", Breach{Metric: "security_scan"})
+		} else {
+			state.SecurityScan = scan
+			if scan.Grade == "" {
+				details += " Security scan queued, no grade yet."
+			} else {
+				details += fmt.Sprintf(" Security scan grade %s.", scan.Grade)
+				if securityGradeRank(scan.Grade) < securityGradeRank(cfg.SecurityScanMinGrade) {
+					alert(StateWarning, "Security Scan Grade "+scan.Grade, Breach{Metric: "security_scan", Threshold: cfg.SecurityScanMinGrade})
+				}
+			}
+		}
+	}
+
+	// Debug logging (loglevel 0) or debug mode floods the log and may leak
+	// data, which is only a problem on production instances.
+	if evaluate("logging") {
+		debugLogging := sysInfo.Debug || (sysInfo.LogLevel != nil && *sysInfo.LogLevel == 0)
+		production := cfg.Production == ProductionOn
+		if cfg.Production == ProductionAuto {
+			production = appearsProduction(cfg.ServerURL, cfg.HostHeader)
+			debugf(cfg, "instance appears to be production: %v", production)
+		}
+		if production && debugLogging {
+			alert(StateWarning, "Debug Logging Enabled", Breach{Metric: "logging"})
+		}
+		if cfg.MaxLogSize > 0 && sysInfo.LogFileSize != nil && *sysInfo.LogFileSize > cfg.MaxLogSize {
+			alert(StateWarning, "Log File Too Large", Breach{Metric: "logfile_size_bytes", Threshold: strconv.FormatInt(cfg.MaxLogSize, 10)})
+		}
+	}
+
+	// A disabled opcache means every request recompiles PHP, which is a
+	// misconfiguration rather than a low hit rate.
+	opcache := ocsResp.OCS.Data.Server.PHP.Opcache
+	if evaluate("opcache") && opcache.OpcacheEnabled != nil && !*opcache.OpcacheEnabled {
+		details += " PHP opcache is disabled."
+		if cfg.OpcacheDisabledState > StateOK {
+			alert(cfg.OpcacheDisabledState, "PHP Opcache Disabled", Breach{Metric: "opcache"})
+		}
+	}
+
+	// A low hit rate means scripts are evicted or the cache is too small.
+	// It fluctuates after restarts, so it goes through the grace period.
+	hitRate := opcacheStats.OpcacheHitRate
+	if evaluate("opcache") && (opcache.OpcacheEnabled == nil || *opcache.OpcacheEnabled) {
+		if cfg.OpcacheHitRateCrit > 0 && hitRate < cfg.OpcacheHitRateCrit {
+			raise("opcache_hit_rate", StateCritical, fmt.Sprintf("PHP Opcache Hit Rate %.1f%%", hitRate), Breach{Metric: "opcache_hit_rate", Threshold: formatThreshold(cfg.OpcacheHitRateCrit) + ":"})
+		} else if cfg.OpcacheHitRateWarn > 0 && hitRate < cfg.OpcacheHitRateWarn {
+			raise("opcache_hit_rate", StateWarning, fmt.Sprintf("PHP Opcache Hit Rate %.1f%%", hitRate), Breach{Metric: "opcache_hit_rate", Threshold: formatThreshold(cfg.OpcacheHitRateWarn) + ":"})
+		}
+	}
+
+	// Once num_cached_keys reaches max_cached_keys the opcache stops adding
+	// scripts and every further one is compiled on each request.
+	cachedKeysPercent, hasCachedKeysPercent := 0.0, false
+	if opcacheStats.NumCachedKeys != nil && opcacheStats.MaxCachedKeys != nil && *opcacheStats.MaxCachedKeys > 0 {
+		cachedKeysPercent, hasCachedKeysPercent = float64(*opcacheStats.NumCachedKeys)/float64(*opcacheStats.MaxCachedKeys)*100, true
+		if evaluate("opcache_keys") && cfg.OpcacheKeysPercentWarn > 0 && cachedKeysPercent > cfg.OpcacheKeysPercentWarn {
+			raise("opcache_cached_keys_percent", StateWarning, fmt.Sprintf("PHP Opcache Keys %.1f%% Used (raise opcache.max_accelerated_files)", cachedKeysPercent), Breach{Metric: "opcache_cached_keys_percent", Threshold: formatThreshold(cfg.OpcacheKeysPercentWarn)})
+		}
+	}
+
+	// The opcache and interned strings buffers as a share of the PHP memory
+	// limit, to size opcache.memory_consumption and memory_limit together.
+	// An unlimited or unknown limit skips the fractions.
+	memoryLimit := int64(0)
+	if cfg.PHPMemoryLimit != 0 {
+		memoryLimit = cfg.PHPMemoryLimit
+	} else if ocsResp.OCS.Data.Server.PHP.MemoryLimit != nil {
+		memoryLimit = *ocsResp.OCS.Data.Server.PHP.MemoryLimit
+	}
+	opcacheMemoryPercent, hasOpcacheMemoryPercent := 0.0, false
+	internedStringsPercent, hasInternedStringsPercent := 0.0, false
+	if memoryLimit > 0 && opcache.MemoryUsage != nil {
+		opcacheMemoryPercent, hasOpcacheMemoryPercent = float64(opcache.MemoryUsage.UsedMemory)/float64(memoryLimit)*100, true
+	}
+	if memoryLimit > 0 && opcache.InternedStringsUsage != nil {
+		internedStringsPercent, hasInternedStringsPercent = float64(opcache.InternedStringsUsage.UsedMemory)/float64(memoryLimit)*100, true
+	}
+
+	// The share of slow queries does not depend on whether the server
+	// reports the counters since start or for a recent window.
+	database := ocsResp.OCS.Data.Server.Database
+	slowQueryPercent, hasSlowQueryPercent := 0.0, false
+	if database.Queries != nil && database.SlowQueries != nil && *database.Queries > 0 {
+		slowQueryPercent, hasSlowQueryPercent = float64(*database.SlowQueries)/float64(*database.Queries)*100, true
+		if evaluate("database") && cfg.DBSlowQueryPercentWarn > 0 && slowQueryPercent > cfg.DBSlowQueryPercentWarn {
+			raise("db_slow_query_percent", StateWarning, fmt.Sprintf("%.1f%% Slow Database Queries", slowQueryPercent), Breach{Metric: "db_slow_query_percent", Threshold: formatThreshold(cfg.DBSlowQueryPercentWarn)})
 		}
-	} else if swapUsage > 80 {
-		status = "WARNING - High Swap Usage"
-		if exitCode < 1 {
-			exitCode = 1
+	}
+
+	// Missing indices or columns after an upgrade leave the instance working
+	// but slow until the matching occ db:add-missing-* command is run.
+	pendingMigrations, hasPendingMigrations := 0, false
+	if database.MissingIndices != nil || database.MissingColumns != nil || database.MissingPrimaryKeys != nil {
+		var pending []string
+		for _, repair := range []struct {
+			name  string
+			items []string
+		}{
+			{"indices", database.MissingIndices},
+			{"columns", database.MissingColumns},
+			{"primary keys", database.MissingPrimaryKeys},
+		} {
+			if len(repair.items) > 0 {
+				pending = append(pending, fmt.Sprintf("%d %s", len(repair.items), repair.name))
+				pendingMigrations += len(repair.items)
+			}
+		}
+		hasPendingMigrations = true
+		if evaluate("db_migrations") && len(pending) > 0 {
+			alert(StateWarning, "Pending Database Migrations (missing "+strings.Join(pending, ", ")+")", Breach{Metric: "db_pending_migrations", Threshold: "0"})
 		}
 	}
 
-	if sysInfo.Apps.NumUpdatesAvailable > 0 {
-		status = "WARNING - App Updates Available"
-		if exitCode < 1 {
-			exitCode = 1
+	numUsers := ocsResp.OCS.Data.Nextcloud.Storage.NumUsers
+
+	// Instances without users have no meaningful ratio and skip the check.
+	sharesPerUser, hasSharesPerUser := 0.0, false
+	if numUsers > 0 {
+		sharesPerUser, hasSharesPerUser = float64(ocsResp.OCS.Data.Nextcloud.Shares.NumShares)/float64(numUsers), true
+		if evaluate("shares_per_user") && cfg.SharesPerUserWarn > 0 && sharesPerUser > cfg.SharesPerUserWarn {
+			alert(StateWarning, fmt.Sprintf("%.2f Shares Per User", sharesPerUser), Breach{Metric: "shares_per_user", Threshold: formatThreshold(cfg.SharesPerUserWarn)})
 		}
 	}
 
-	if sysInfo.Update.Available {
-		status = "WARNING - Nextcloud Update Available (" + sysInfo.Update.AvailableVersion + ")"
-		if exitCode < 1 {
-			exitCode = 1
+	usersPercent := 0.0
+	if cfg.UserCap > 0 {
+		usersPercent = float64(numUsers) / float64(cfg.UserCap) * 100
+		details += fmt.Sprintf(" %d/%d users (%.1f%%).", numUsers, cfg.UserCap, usersPercent)
+
+		if evaluate("user_cap") {
+			if usersPercent > cfg.UsersPercentCrit {
+				alert(StateCritical, "User Cap Nearly Reached", Breach{Metric: "num_users_percent", Threshold: formatThreshold(cfg.UsersPercentCrit)})
+			} else if usersPercent > cfg.UsersPercentWarn {
+				alert(StateWarning, "User Cap Nearly Reached", Breach{Metric: "num_users_percent", Threshold: formatThreshold(cfg.UsersPercentWarn)})
+			}
 		}
 	}
 
 	metrics := map[string]interface{}{
-		"num_users":                 ocsResp.OCS.Data.Nextcloud.Storage.NumUsers,
+		"version":                   sysInfo.Version,
+		"num_users":                 numUsers,
 		"num_files":                 ocsResp.OCS.Data.Nextcloud.Storage.NumFiles,
-		"cpu_load_1m":               sysInfo.Cpuload[0],
-		"cpu_load_5m":               sysInfo.Cpuload[1],
-		"cpu_load_15m":              sysInfo.Cpuload[2],
+		"num_storages":              numStorages,
 		"memory_total":              memTotal,
 		"memory_free":               memFree,
 		"memory_usage_percent":      math.Round(memUsage*100) / 100,
@@ -240,28 +987,895 @@ func checkNextcloud(serverURL string, ncToken string) {
 		"active_users_6mo":          ocsResp.OCS.Data.ActiveUsers.Last6months,
 		"active_users_1y":           ocsResp.OCS.Data.ActiveUsers.Lastyear,
 		"opcache_hit_rate":          ocsResp.OCS.Data.Server.PHP.Opcache.OpcacheStatistics.OpcacheHitRate,
+		"response_bytes":            ocsResp.BodyBytes,
+	}
+
+	if sysInfo.CPUSteal != nil {
+		metrics["cpu_steal_percent"] = *sysInfo.CPUSteal
+	}
+
+	if sysInfo.CPUIowait != nil {
+		metrics["cpu_iowait_percent"] = *sysInfo.CPUIowait
+	}
+
+	if len(sysInfo.Cpuload) >= 3 {
+		metrics["cpu_load_1m"] = sysInfo.Cpuload[0]
+		metrics["cpu_load_5m"] = sysInfo.Cpuload[1]
+		metrics["cpu_load_15m"] = sysInfo.Cpuload[2]
+	}
+
+	if cfg.UserCap > 0 {
+		metrics["num_users_percent"] = math.Round(usersPercent*100) / 100
+	}
+
+	if hasMemDelta {
+		metrics["memory_usage_delta"] = math.Round(memDelta*100) / 100
+	}
+
+	if hasStoragesDelta {
+		metrics["num_storages_delta"] = storagesDelta
+	}
+
+	if opcacheStats.OOMRestarts != nil {
+		metrics["opcache_oom_restarts"] = *opcacheStats.OOMRestarts
+	}
+
+	if opcacheStats.HashRestarts != nil {
+		metrics["opcache_hash_restarts"] = *opcacheStats.HashRestarts
+	}
+
+	if opcacheStats.ManualRestarts != nil {
+		metrics["opcache_manual_restarts"] = *opcacheStats.ManualRestarts
+	}
+
+	if opcacheStats.NumCachedScripts != nil {
+		metrics["opcache_cached_scripts"] = *opcacheStats.NumCachedScripts
+	}
+
+	if hasCachedKeysPercent {
+		metrics["opcache_cached_keys_percent"] = math.Round(cachedKeysPercent*100) / 100
+	}
+
+	if hasOpcacheMemoryPercent {
+		metrics["php_opcache_memory_percent"] = math.Round(opcacheMemoryPercent*100) / 100
+	}
+
+	if hasInternedStringsPercent {
+		metrics["php_interned_strings_percent"] = math.Round(internedStringsPercent*100) / 100
+	}
+
+	if hasOOMRestartRate {
+		metrics["opcache_oom_restart_rate"] = math.Round(oomRestartRate*100) / 100
+	}
+
+	if sysInfo.LogFileSize != nil {
+		metrics["logfile_size_bytes"] = *sysInfo.LogFileSize
+	}
+
+	// serverinfo only reports the load averages of the whole host, the core
+	// count lets graphers normalize them per core.
+	if sysInfo.CPUNum > 0 {
+		metrics["ncpu"] = sysInfo.CPUNum
+	}
+
+	if hasFilesChange {
+		metrics["num_files_change_percent"] = math.Round(filesChange*100) / 100
+	}
+
+	if hasFilesPerDay {
+		metrics["num_files_per_day"] = math.Round(filesPerDay*100) / 100
+	}
+
+	if hasDaysUntilLimit {
+		metrics["num_files_days_until_limit"] = math.Round(daysUntilLimit*10) / 10
+	}
+
+	if database.Queries != nil {
+		metrics["db_queries"] = *database.Queries
+	}
+
+	if database.SlowQueries != nil {
+		metrics["db_slow_queries"] = *database.SlowQueries
+	}
+
+	if hasPendingMigrations {
+		metrics["db_pending_migrations"] = pendingMigrations
+	}
+
+	for name, value := range appCountValues {
+		metrics[appMetricPrefix+name] = value
+	}
+
+	if hasSlowQueryPercent {
+		metrics["db_slow_query_percent"] = math.Round(slowQueryPercent*100) / 100
+	}
+
+	if hasSharesPerUser {
+		metrics["shares_per_user"] = math.Round(sharesPerUser*100) / 100
+	}
+
+	if hasExternalUsage {
+		metrics["external_storage_usage_percent"] = math.Round(externalUsage*100) / 100
+	}
+
+	if hasObjectStorageLatency {
+		metrics["object_storage_latency_ms"] = objectStorageLatency.Milliseconds()
+	}
+
+	if hasSkew {
+		metrics["clock_skew_seconds"] = int64(math.Round(skew))
+	}
+
+	if cfg.UnconfiguredChecks == "skip" {
+		for metric := range unconfiguredMetrics(cfg) {
+			delete(metrics, metric)
+		}
+	}
+
+	if cfg.OnlyMetrics {
+		status = "OK"
+		exitCode = 0
+		breaches = nil
+	}
+	for i := range breaches {
+		if value, ok := metrics[breaches[i].Metric]; ok && breaches[i].Value == nil {
+			breaches[i].Value = value
+		}
+	}
+
+	if cfg.StatusPerfdata {
+		metrics["nagios_status"] = exitCode
+	}
+
+	if cfg.StateFile != "" {
+		saveState(cfg.StateFile, state)
+	}
+
+	if cfg.Metric != "" {
+		value, ok := metrics[cfg.Metric]
+		if !ok {
+			return "", 0, nil, withPlainHTTP(fmt.Errorf("metric %s not available", cfg.Metric))
+		}
+		return fmt.Sprint(value), 0, nil, nil
+	}
+
+	// Thresholds are only attached for checks that are evaluated in this run
+	// and must mirror the values used above.
+	thresholds := map[string]PerfThreshold{}
+	if !cfg.OnlyMetrics {
+		if cfg.checkEnabled("cpu_load") && cfg.CPUExpectedUsers == 0 {
+			thresholds["cpu_load_1m"] = PerfThreshold{Warn: formatThreshold(cfg.CPULoadWarn[0])}
+			thresholds["cpu_load_5m"] = PerfThreshold{Warn: formatThreshold(cfg.CPULoadWarn[1])}
+			thresholds["cpu_load_15m"] = PerfThreshold{Warn: formatThreshold(cfg.CPULoadWarn[2])}
+		}
+		if cfg.checkEnabled("memory") {
+			thresholds["memory_usage_percent"] = PerfThreshold{Warn: formatThreshold(memoryWarnPercent), Crit: formatThreshold(memoryCritPercent)}
+		}
+		if cfg.checkEnabled("swap") {
+			thresholds["swap_usage_percent"] = PerfThreshold{Warn: formatThreshold(swapWarnPercent), Crit: formatThreshold(swapCritPercent)}
+		}
+		if cfg.checkEnabled("app_updates") {
+			thresholds["num_apps_update_available"] = PerfThreshold{Warn: strconv.Itoa(cfg.AppUpdatesWarn)}
+		}
+		if cfg.checkEnabled("clock_skew") && cfg.MaxSkew > 0 {
+			maxSkew := formatThreshold(cfg.MaxSkew.Seconds())
+			thresholds["clock_skew_seconds"] = PerfThreshold{Warn: "-" + maxSkew + ":" + maxSkew}
+		}
+		if cfg.checkEnabled("files_drop") && cfg.FilesDropCrit > 0 {
+			thresholds["num_files_change_percent"] = PerfThreshold{Crit: formatThreshold(-cfg.FilesDropCrit) + ":"}
+		}
+		if cfg.checkEnabled("files_growth") && cfg.FilesLimit > 0 && cfg.FilesHorizonDays > 0 {
+			thresholds["num_files_days_until_limit"] = PerfThreshold{Warn: formatThreshold(float64(cfg.FilesHorizonDays)) + ":"}
+		}
+		if cfg.checkEnabled("database") && cfg.DBSlowQueryPercentWarn > 0 {
+			thresholds["db_slow_query_percent"] = PerfThreshold{Warn: formatThreshold(cfg.DBSlowQueryPercentWarn)}
+		}
+		if cfg.checkEnabled("db_migrations") {
+			thresholds["db_pending_migrations"] = PerfThreshold{Warn: "0"}
+		}
+		if cfg.checkEnabled("opcache_keys") && cfg.OpcacheKeysPercentWarn > 0 {
+			thresholds["opcache_cached_keys_percent"] = PerfThreshold{Warn: formatThreshold(cfg.OpcacheKeysPercentWarn)}
+		}
+		if cfg.checkEnabled("opcache") && (cfg.OpcacheHitRateWarn > 0 || cfg.OpcacheHitRateCrit > 0) {
+			threshold := PerfThreshold{}
+			if cfg.OpcacheHitRateWarn > 0 {
+				threshold.Warn = formatThreshold(cfg.OpcacheHitRateWarn) + ":"
+			}
+			if cfg.OpcacheHitRateCrit > 0 {
+				threshold.Crit = formatThreshold(cfg.OpcacheHitRateCrit) + ":"
+			}
+			thresholds["opcache_hit_rate"] = threshold
+		}
+		if cfg.checkEnabled("opcache_restarts") && cfg.OpcacheOOMRestartsWarn > 0 {
+			thresholds["opcache_oom_restart_rate"] = PerfThreshold{Warn: formatThreshold(cfg.OpcacheOOMRestartsWarn)}
+		}
+		if cfg.checkEnabled("storages_growth") && cfg.StoragesGrowthWarn > 0 {
+			thresholds["num_storages_delta"] = PerfThreshold{Warn: strconv.Itoa(cfg.StoragesGrowthWarn)}
+		}
+		if cfg.checkEnabled("shares_per_user") && cfg.SharesPerUserWarn > 0 {
+			thresholds["shares_per_user"] = PerfThreshold{Warn: formatThreshold(cfg.SharesPerUserWarn)}
+		}
+		if cfg.checkEnabled("external_storage") {
+			thresholds["external_storage_usage_percent"] = PerfThreshold{Warn: formatThreshold(cfg.ExternalStorageWarn), Crit: formatThreshold(cfg.ExternalStorageCrit)}
+		}
+		if cfg.checkEnabled("object_storage") && cfg.ObjectStorageLatencyWarn > 0 {
+			thresholds["object_storage_latency_ms"] = PerfThreshold{Warn: formatThreshold(float64(cfg.ObjectStorageLatencyWarn.Milliseconds()))}
+		}
+		if cfg.checkEnabled("user_cap") && cfg.UserCap > 0 {
+			thresholds["num_users_percent"] = PerfThreshold{Warn: formatThreshold(cfg.UsersPercentWarn), Crit: formatThreshold(cfg.UsersPercentCrit)}
+		}
+	}
+
+	perfdataOutput := formatPerfdata(metrics, thresholds, cfg.PerfdataFields, cfg.Precision)
+
+	if cfg.PerfdataFile != "" {
+		writePerfdataFile(cfg.PerfdataFile, perfdataOutput)
+	}
+	// Structured outputs feed external tooling, which may want the version
+	// padded for comparisons. The human readable line keeps it as reported.
+	outputVersion := sysInfo.Version
+	if cfg.NormalizeVersion {
+		outputVersion = normalizeVersion(outputVersion)
+	}
+
+	if cfg.PrometheusFile != "" {
+		writePrometheusFile(cfg.PrometheusFile, formatPrometheus(cfg.MetricPrefix, serverHost(cfg.ServerURL), outputVersion, cfg.Tags, metrics, false))
+	}
+
+	metricsOutput := ""
+	if !cfg.NoPerfdata {
+		metricsOutput = " | " + perfdataOutput
+	}
+
+	version := sysInfo.Version
+	if sysInfo.Edition != "" {
+		version += " (" + sysInfo.Edition + ")"
+	}
+
+	// The min-version status already names the running version, repeating it
+	// in the summary would only lengthen the line.
+	message := fmt.Sprintf("%s - Nextcloud %s running.%s", status, version, details)
+	if strings.Contains(status, "Nextcloud "+sysInfo.Version+" below minimum version") {
+		message = status + "." + details
+	}
+
+	// The summary backs --output json and is posted to --webhook-url
+	// whatever the output format.
+	summary := newHealthSummary(serverHost(cfg.ServerURL), outputVersion, message, exitCode, cfg.Tags, metrics, breaches, time.Now())
+
+	if cfg.Output == "influx" {
+		return formatInflux(influxMeasurement(cfg.MetricPrefix), serverHost(cfg.ServerURL), outputVersion, cfg.Tags, metrics, time.Now()), exitCode, &summary, nil
 	}
 
-	metricsOutput := " |"
-	for key, value := range metrics {
-		metricsOutput += fmt.Sprintf(" %s=%v", key, value)
+	if cfg.Output == "graphite" {
+		return formatGraphite(cfg.GraphitePrefix, serverHost(cfg.ServerURL), metrics, time.Now()), exitCode, &summary, nil
 	}
 
-	fmt.Printf("%s - Nextcloud %s running.%s\n", status, sysInfo.Version, metricsOutput)
-	os.Exit(exitCode)
+	if cfg.Output == "prometheus" || cfg.Output == "openmetrics" {
+		return formatPrometheus(cfg.MetricPrefix, serverHost(cfg.ServerURL), outputVersion, cfg.Tags, metrics, cfg.Output == "openmetrics"), exitCode, &summary, nil
+	}
+
+	if cfg.Output == "score" {
+		utilization := map[string]float64{
+			"memory":  memUsage,
+			"swap":    swapUsage,
+			"opcache": 100 - ocsResp.OCS.Data.Server.PHP.Opcache.OpcacheStatistics.OpcacheHitRate,
+		}
+		if len(sysInfo.Cpuload) > 0 {
+			cpus := math.Max(1, float64(sysInfo.CPUNum))
+			utilization["cpu"] = sysInfo.Cpuload[0] / cpus * 100
+		}
+		if sysInfo.DiskTotal != nil && *sysInfo.DiskTotal > 0 {
+			utilization["disk"] = float64(*sysInfo.DiskTotal-sysInfo.FreeSpace) / float64(*sysInfo.DiskTotal) * 100
+		}
+
+		score := math.Round(healthScore(utilization, cfg.ScoreWeights)*10) / 10
+		scoreExit := scoreState(score)
+		if cfg.OnlyMetrics {
+			scoreExit = 0
+		}
+		scoreOutput := fmt.Sprintf("%s - Nextcloud health score %v/100", stateNames[scoreExit], score)
+		summary.State, summary.ExitCode, summary.Message = stateNames[scoreExit], scoreExit, scoreOutput
+		summary.Metrics["health_score"] = score
+		if !cfg.NoPerfdata {
+			scoreOutput += " | health_score=" + fmt.Sprint(score) + ";" + formatThreshold(scoreWarning) + ":;" + formatThreshold(scoreCritical) + ": " + perfdataOutput
+		}
+		return scoreOutput, scoreExit, &summary, nil
+	}
+
+	if cfg.Output == "json" {
+		output, err := formatJSON(summary)
+		if err != nil {
+			return "", 0, nil, withPlainHTTP(err)
+		}
+		return output, exitCode, &summary, nil
+	}
+
+	// --summary-only shortens the nagios status line only, the structured
+	// outputs above keep their full document.
+	if cfg.SummaryOnly {
+		return pluginText(status) + metricsOutput, exitCode, &summary, nil
+	}
+
+	output := pluginText(message) + metricsOutput
+
+	// With external mounts the long output lists every storage, the first
+	// line stays the summary.
+	storage := ocsResp.OCS.Data.Nextcloud.Storage
+	if evaluate("external_storage") && len(storage.ExternalStorages) > 0 {
+		dataDir := StorageRow{Name: "Data directory", Free: sysInfo.FreeSpace}
+		if sysInfo.DiskTotal != nil {
+			dataDir.Total = *sysInfo.DiskTotal
+		}
+		rows := []StorageRow{dataDir}
+		for _, mount := range storage.ExternalStorages {
+			rows = append(rows, StorageRow{Name: mount.MountPoint, Free: mount.Free, Total: mount.Total})
+		}
+		output += "\n" + pluginText(formatStorageTable(rows))
+	}
+	return output, exitCode, &summary, nil
 }
 
 func main() {
 	server := flag.String("s", "", "Nextcloud Server URL (e.g. https://nextcloud.example.com)")
 	token := flag.String("t", "", "Nextcloud NC-Token for API access")
+	webhookURL := flag.String("webhook-url", "", "POST the result as JSON to this URL when the state is not OK (best effort)")
+	webhookOn := flag.String("webhook-on", "warning", "Least severe state sent to --webhook-url: warning, unknown or critical")
+	logFile := flag.String("log-file", "", "Append a JSON line audit record of each run to this file")
+	perfdataFile := flag.String("perfdata-file", "", "Append timestamped performance data to this file")
+	prometheusFile := flag.String("prometheus-file", "", "Also write the metrics in Prometheus text format to this file, e.g. for the node_exporter textfile collector")
+	noPerfdata := flag.Bool("no-perfdata", false, "Omit performance data from the status line")
+	var appCounts AppCounts
+	flag.Var(&appCounts, "app-count", "App-specific count emitted as app_<name> perfdata, name=/path[#field] of a JSON endpoint (repeatable)")
+	var requiredApps AppList
+	flag.Var(&requiredApps, "check-app", "App id that must be installed and enabled, CRITICAL when missing (repeatable or comma-separated, requires --apps-user)")
+	appsUser := flag.String("apps-user", "", "Admin user for the provisioning API queried by --check-app and the --app-count endpoints")
+	appsPassword := flag.String("apps-password", "", "App password of --apps-user")
+	talkHPBURL := flag.String("talk-hpb-url", "", "Talk high-performance backend welcome URL (e.g. https://signaling.example.com/api/v1/welcome)")
+	minVersion := flag.String("min-version", "", "Minimum supported Nextcloud version (e.g. 29.0.0)")
+	minVersionCritical := flag.Bool("min-version-critical", false, "Raise CRITICAL instead of WARNING when below --min-version")
+	expectedEdition := flag.String("expected-edition", "", "WARNING when the reported Nextcloud edition differs (e.g. enterprise)")
+	unixSocket := flag.String("unix-socket", "", "Connect to the server through this unix domain socket instead of TCP")
+	onlyMetrics := flag.Bool("only-metrics", false, "Collect performance data only and always exit OK")
+	format := flag.String("format", "json", "Response format requested from serverinfo (supported: json)")
+	memAvailable := flag.Bool("mem-available", false, "Count buffers and cache as available memory when reported by serverinfo")
+	requireSwap := flag.Bool("require-swap", false, "WARNING when no swap is configured")
+	mode := flag.String("mode", "all", "Evaluate only the named check (see --list-checks)")
+	quiet := flag.Bool("quiet", false, "Print nothing on stdout and only set the exit code; errors go to stderr")
+	cpuLoadWarn := flag.String("cpu-load-warn", "5,4,3", "WARNING thresholds for the 1m,5m,15m load averages")
+	cpuStealPercent := flag.Float64("cpu-steal-percent", 0, "Report high CPU load as hypervisor contention when at least this percentage of CPU time is stolen (0 disables)")
+	cpuStealState := flag.String("cpu-steal-state", "warning", "State raised for high CPU load caused by steal time (ok, warning, critical)")
+	businessHours := flag.String("business-hours", "", "Daily window in local time, e.g. 08:00-18:00, during which no active users in the last hour warns")
+	cpuExpectedUsers := flag.Int("cpu-expected-users", 0, "Only warn on high CPU load when fewer than this many users were active in the last 5 minutes (0 disables)")
+	output := flag.String("output", "nagios", "Output format: nagios, influx, json, score, prometheus, openmetrics or graphite")
+	probeFirst := flag.Bool("probe-first", false, "Send a HEAD request to the base URL before querying serverinfo")
+	userCap := flag.Int("user-cap", 0, "Maximum number of users; enables the user_cap check and num_users_percent perfdata")
+	usersPercentWarn := flag.Float64("users-percent-warn", 80, "WARNING threshold for the percentage of --user-cap in use")
+	usersPercentCrit := flag.Float64("users-percent-crit", 90, "CRITICAL threshold for the percentage of --user-cap in use")
+	unconfiguredChecks := flag.String("unconfigured-checks", "metrics-only", "Handling of checks without thresholds: skip or metrics-only")
+	checkTrustedDomains := flag.Bool("check-trusted-domains", false, "WARNING when the server URL host is not in the trusted domains reported by serverinfo")
+	timeout := flag.Duration("timeout", 30*time.Second, "Overall timeout for the check (e.g. 10s)")
+	retries := flag.Int("retries", 0, "Retry transient serverinfo failures (connection errors, truncated responses) this many times")
+	retryBackoff := flag.Duration("retry-backoff", time.Second, "Base delay of the exponential backoff with jitter between retries")
+	gracePeriod := flag.String("grace-period", "", "Consecutive breaching runs before fluctuating metrics alert, N or e.g. warning=3,critical=2 (requires --state-file)")
+	http2Only := flag.Bool("http2-only", false, "Request HTTP/2 from the server and fail on any response over another protocol (https only)")
+	cacheTTL := flag.Duration("cache-ttl", 0, "Reuse the serverinfo response of an earlier run younger than this, e.g. 60s when several services check the same instance (0 disables)")
+	timeoutRetry := flag.Duration("timeout-retry", 0, "Retry serverinfo once with this longer timeout when the first request times out (e.g. 60s, 0 disables)")
+	connectTimeout := flag.Duration("connect-timeout", 0, "Timeout for establishing each connection (e.g. 3s, 0 uses --timeout)")
+	perfdataFieldsSpec := flag.String("perfdata-fields", "", "Comma-separated list of metrics to include in perfdata (default all)")
+	maxSkew := flag.Duration("max-skew", 0, "WARNING when the server clock differs by more than this (e.g. 60s, 0 disables)")
+	precisionSpec := flag.String("precision", "", "Perfdata decimal digits per metric type, e.g. percent=1,load=2,rate=2,bytes=0 (-1 disables rounding)")
+	metric := flag.String("metric", "", "Print only the raw value of this metric and exit OK")
+	apiPaths := flag.String("api-path", "", "Comma-separated serverinfo endpoint paths, tried in order (default "+strings.Join(defaultAPIPaths(defaultServerinfoVersion), ",")+")")
+	serverinfoVersion := flag.String("serverinfo-version", defaultServerinfoVersion, "API version segment of the default serverinfo endpoint paths, e.g. v2")
+	debug := flag.Bool("debug", false, "Write diagnostic output to stderr")
+	stateFile := flag.String("state-file", "", "File used to persist values between runs")
+	checkDowngrade := flag.Bool("check-downgrade", false, "CRITICAL when the version is lower than the one recorded in --state-file")
+	storagesGrowthWarn := flag.Int("storages-growth-warn", 0, "WARNING when the number of storages grew by more than this since the last run (requires --state-file, 0 disables)")
+	memoryGrowthWarn := flag.Float64("memory-growth-warn", 0, "WARNING when memory usage grows faster than this many percentage points per hour (requires --state-file, 0 disables)")
+	securityScanMinGrade := flag.String("security-scan-min-grade", "", "Query scan.nextcloud.com and WARNING below this grade (A+, A, C, D, E, F, requires --state-file)")
+	securityScanMaxAge := flag.Duration("security-scan-max-age", 24*time.Hour, "Reuse the cached security scan grade for this long before reading the latest result and queueing a new scan")
+	exitMapSpec := flag.String("exit-map", "", "Remap exit codes, e.g. warning=0,unknown=2")
+	statusPerfdata := flag.Bool("status-perfdata", false, "Add the final check state (0-3) as nagios_status perfdata")
+	baselineFile := flag.String("baseline-file", "", "WARNING when PHP version, database type, installed app count, edition or webserver differ from this baseline (recorded on the first run)")
+	writeBaseline := flag.Bool("write-baseline", false, "Record the current configuration in --baseline-file instead of comparing it")
+	checkUpgrade := flag.Bool("check-upgrade", false, "CRITICAL when status.php reports a pending or stuck upgrade (needsDbUpgrade)")
+	statusFallback := flag.Bool("status-fallback", false, "Report the instance state from status.php when serverinfo cannot be queried")
+	strict := flag.Bool("strict", false, "Report UNKNOWN on impossible serverinfo values (e.g. negative free memory) instead of clamping them")
+	failFast := flag.Bool("fail-fast", false, "Stop evaluating checks at the first CRITICAL and report only that condition")
+	summaryOnly := flag.Bool("summary-only", false, "Print only the status and breached condition, without version and details")
+	production := ProductionOff
+	flag.Var(&production, "production", "Treat the instance as production and WARNING on debug logging: true, false or auto (guess from the server URL)")
+	maxLogSize := flag.Int64("max-log-size", 0, "WARNING when the reported log file size exceeds this many bytes (0 disables)")
+	allowHTTP := flag.Bool("allow-http", false, "Allow a plain http:// server URL without warning")
+	requireHTTPS := flag.Bool("require-https", false, "Raise CRITICAL instead of WARNING for a plain http:// server URL")
+	scoreWeightsSpec := flag.String("score-weights", "", "Weights of the health score components, e.g. memory=2,swap=1,cpu=1,disk=1,opcache=1")
+	filesDropCrit := flag.Float64("files-drop-crit", 0, "CRITICAL when the number of files dropped by more than this percentage since the last run (requires --state-file, 0 disables)")
+	filesWindow := flag.Duration("files-window", 7*24*time.Hour, "History used for the num_files growth rate (requires --state-file)")
+	filesLimit := flag.Int("files-limit", 0, "File count the num_files growth is projected against (0 disables)")
+	filesHorizonDays := flag.Int("files-horizon-days", 0, "WARNING when --files-limit is projected to be reached within this many days (0 disables)")
+	externalStorageWarn := flag.Float64("external-storage-warn", 90, "WARNING threshold for the usage percentage of any external mount")
+	externalStorageCrit := flag.Float64("external-storage-crit", 95, "CRITICAL threshold for the usage percentage of any external mount")
+	checkServerIdentity := flag.Bool("check-server-identity", false, "WARNING when the TLS certificate (or --identity-header) does not match the expected identity")
+	expectedIdentity := flag.String("expected-identity", "", "Host name expected by --check-server-identity (default the -s host, or the --host-header name when set)")
+	identityHeader := flag.String("identity-header", "", "Response header compared against the expected identity instead of the certificate, e.g. X-Backend-Server")
+	checkProxyHeaders := flag.Bool("check-proxy-headers", false, "WARNING when the scheme or host of the URLs Nextcloud generates differs from the expected base URL")
+	expectedBaseURL := flag.String("expected-base-url", "", "Base URL Nextcloud should generate links for with --check-proxy-headers (default the -s URL)")
+	objectStorageURL := flag.String("object-storage-url", "", "Object storage endpoint or bucket URL probed for reachability and latency (e.g. https://s3.example.com/nextcloud)")
+	objectStorageLatencyWarn := flag.Duration("object-storage-latency-warn", 0, "WARNING when the object storage probe takes longer than this (e.g. 500ms, 0 disables)")
+	proxy := flag.String("proxy", "", "Forward proxy URL (e.g. http://proxy.example.com:3128), defaults to the HTTP(S)_PROXY environment")
+	proxyUser := flag.String("proxy-user", "", "User for proxy authentication (requires --proxy)")
+	proxyPassword := flag.String("proxy-password", "", "Password for proxy authentication (requires --proxy-user)")
+	hostHeader := flag.String("host-header", "", "Host header (and TLS server name) sent to the server while connecting to the -s address")
+	cfClientID := flag.String("cf-client-id", "", "Cloudflare Access service token client ID (requires --cf-client-secret)")
+	cfClientSecret := flag.String("cf-client-secret", "", "Cloudflare Access service token client secret (requires --cf-client-id)")
+	primaryHost := flag.String("check-only-if-primary", "", "Run the full check only when the server URL host or local hostname matches this primary node, otherwise only check reachability")
+	expectedTimezone := flag.String("expected-timezone", "", "WARNING when the reported server timezone differs (e.g. Europe/Berlin)")
+	expectedLocale := flag.String("expected-locale", "", "WARNING when the reported default locale differs (e.g. de_DE)")
+	dbSlowQueryPercentWarn := flag.Float64("db-slow-query-percent-warn", 0, "WARNING when more than this percentage of database queries are slow (0 disables)")
+	sharesPerUserWarn := flag.Float64("shares-per-user-warn", 0, "WARNING when the number of shares per user exceeds this (0 disables)")
+	appUpdatesWarn := flag.Int("updates-warn", 0, "WARNING only when more than this many app updates are available")
+	phpMemoryLimit := flag.String("php-memory-limit", "", "PHP memory_limit (e.g. 512M) the opcache memory fractions are reported against (default: as reported by serverinfo)")
+	opcacheKeysPercentWarn := flag.Float64("opcache-keys-percent-warn", 0, "WARNING when more than this percentage of the opcache keys (opcache.max_accelerated_files) is used (0 disables)")
+	opcacheHitRateWarn := flag.Float64("opcache-hit-rate-warn", 0, "WARNING when the PHP opcache hit rate falls below this percentage (0 disables)")
+	opcacheHitRateCrit := flag.Float64("opcache-hit-rate-crit", 0, "CRITICAL when the PHP opcache hit rate falls below this percentage (0 disables)")
+	opcacheOOMRestartsWarn := flag.Float64("opcache-oom-restarts-warn", 0, "WARNING when the PHP opcache restarts for lack of memory more often than this many times per hour (requires --state-file, 0 disables)")
+	maintenanceState := flag.String("maintenance-state", "warning", "State reported when the server answers in maintenance mode (ok, warning, critical, unknown)")
+	opcacheDisabledState := flag.String("opcache-disabled-state", "warning", "State raised when the PHP opcache is disabled (ok, warning, critical)")
+	normalizeVersionFlag := flag.Bool("normalize-version", false, "Pad the version to four segments (e.g. 30.0.9.0) in json, influx and prometheus output")
+	graphitePrefix := flag.String("graphite-prefix", defaultGraphitePrefix, "Metric path prefix of --output graphite, {host} is replaced by the instance host")
+	metricPrefix := flag.String("metric-prefix", defaultMetricPrefix, "Prefix of the prometheus metric names and influx measurement")
+	tags := Tags{}
+	flag.Var(tags, "tag", "Static key=value tag for json, influx and prometheus output (repeatable)")
+	instancesFile := flag.String("instances-file", "", "Check every \"URL TOKEN\" line of this file instead of -s/-t and report the worst state")
+	compareWith := flag.String("compare-with", "", "Compare version, user, file and app counts of -s with this instance instead of running the checks, e.g. to validate a migration")
+	token2 := flag.String("token2", "", "NC-Token for --compare-with (default the -t token)")
+	compareTolerance := flag.Float64("compare-tolerance", 0, "With --compare-with, percentage by which the counts may differ before warning")
+	top := flag.Int("top", 0, "With --instances-file, list only the N worst instances in the long output (0 lists all)")
+	showChecks := flag.Bool("list-checks", false, "List all supported checks and exit")
+	showModes := flag.String("list-modes", "", "List all modes with their flags in the given format (json) and exit")
 
 	flag.Parse()
 
-	if *server == "" || *token == "" {
+	if *showChecks {
+		listChecks()
+		os.Exit(0)
+	}
+
+	if *showModes != "" {
+		if *showModes != "json" {
+			fmt.Printf("CRITICAL - Unsupported --list-modes format %q (supported: json)\n", *showModes)
+			os.Exit(2)
+		}
+		if err := listModes(); err != nil {
+			fmt.Printf("CRITICAL - Failed to list modes: %v\n", err)
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+
+	var instances []Instance
+	if *instancesFile != "" {
+		if *output != "nagios" || *metric != "" || *stateFile != "" || *baselineFile != "" || *writeBaseline {
+			fmt.Println("CRITICAL - --instances-file only supports --output nagios and cannot be combined with --metric, --state-file, --baseline-file or --write-baseline")
+			os.Exit(2)
+		}
+		parsed, err := parseInstancesFile(*instancesFile)
+		if err != nil {
+			fmt.Printf("CRITICAL - Invalid --instances-file: %v\n", err)
+			os.Exit(2)
+		}
+		instances = parsed
+	} else if *compareWith != "" && (*output != "nagios" || *metric != "" || *stateFile != "" || *mode == "status") {
+		fmt.Println("CRITICAL - --compare-with only supports --output nagios and cannot be combined with --metric, --state-file or --mode status")
+		os.Exit(2)
+	} else if *server == "" || (*token == "" && *mode != "status") {
 		fmt.Println("CRITICAL - Missing required arguments")
 		flag.Usage()
 		os.Exit(2)
 	}
 
-	checkNextcloud(*server, *token)
+	if _, ok := responseFormats[*format]; !ok {
+		fmt.Printf("CRITICAL - Unsupported --format %q (supported: json)\n", *format)
+		os.Exit(2)
+	}
+
+	if *mode != "all" && !isCheck(*mode) {
+		fmt.Printf("CRITICAL - Unknown --mode %q (see --list-checks)\n", *mode)
+		os.Exit(2)
+	}
+
+	loadWarn, err := parseLoadThresholds(*cpuLoadWarn)
+	if err != nil {
+		fmt.Printf("CRITICAL - Invalid --cpu-load-warn: %v\n", err)
+		os.Exit(2)
+	}
+
+	if *unconfiguredChecks != "skip" && *unconfiguredChecks != "metrics-only" {
+		fmt.Printf("CRITICAL - Unknown --unconfigured-checks %q (supported: skip, metrics-only)\n", *unconfiguredChecks)
+		os.Exit(2)
+	}
+
+	perfdataFields, err := parsePerfdataFields(*perfdataFieldsSpec)
+	if err != nil {
+		fmt.Printf("CRITICAL - Invalid --perfdata-fields: %v\n", err)
+		os.Exit(2)
+	}
+
+	precision, err := parsePrecision(*precisionSpec)
+	if err != nil {
+		fmt.Printf("CRITICAL - Invalid --precision: %v\n", err)
+		os.Exit(2)
+	}
+
+	if *metric != "" && !isPerfdataMetric(*metric) {
+		fmt.Printf("CRITICAL - Unknown --metric %q\n", *metric)
+		os.Exit(2)
+	}
+
+	if *securityScanMinGrade != "" && securityGradeRank(*securityScanMinGrade) < 0 {
+		fmt.Printf("CRITICAL - Unknown --security-scan-min-grade %q (supported: %s)\n", *securityScanMinGrade, strings.Join(securityGrades, ", "))
+		os.Exit(2)
+	}
+	if *securityScanMinGrade != "" && *stateFile == "" {
+		fmt.Println("CRITICAL - --security-scan-min-grade requires --state-file")
+		os.Exit(2)
+	}
+	if *securityScanMaxAge <= 0 {
+		fmt.Println("CRITICAL - --security-scan-max-age must be positive")
+		os.Exit(2)
+	}
+
+	err = validateThresholds([]ThresholdPair{
+		{Flag: "users-percent", Warn: *usersPercentWarn, Crit: *usersPercentCrit, Percent: true},
+		{Flag: "external-storage", Warn: *externalStorageWarn, Crit: *externalStorageCrit, Percent: true},
+		{Flag: "opcache-hit-rate", Warn: *opcacheHitRateWarn, Crit: *opcacheHitRateCrit, LowerIsWorse: true, Percent: true, ZeroDisables: true},
+		{Flag: "db-slow-query-percent", Warn: *dbSlowQueryPercentWarn, WarnOnly: true, Percent: true},
+		{Flag: "opcache-keys-percent", Warn: *opcacheKeysPercentWarn, WarnOnly: true, Percent: true},
+		{Flag: "shares-per-user", Warn: *sharesPerUserWarn, WarnOnly: true},
+		{Flag: "object-storage-latency", Warn: objectStorageLatencyWarn.Seconds(), WarnOnly: true},
+		{Flag: "memory-growth", Warn: *memoryGrowthWarn, WarnOnly: true},
+		{Flag: "opcache-oom-restarts", Warn: *opcacheOOMRestartsWarn, WarnOnly: true},
+	})
+	if err != nil {
+		fmt.Printf("CRITICAL - Invalid thresholds: %v\n", err)
+		os.Exit(2)
+	}
+
+	scoreWeights, err := parseScoreWeights(*scoreWeightsSpec)
+	if err != nil {
+		fmt.Printf("CRITICAL - Invalid --score-weights: %v\n", err)
+		os.Exit(2)
+	}
+
+	exitMap, err := parseExitMap(*exitMapSpec)
+	if err != nil {
+		fmt.Printf("CRITICAL - Invalid --exit-map: %v\n", err)
+		os.Exit(2)
+	}
+
+	if *writeBaseline && *baselineFile == "" {
+		fmt.Println("CRITICAL - --write-baseline requires --baseline-file")
+		os.Exit(2)
+	}
+
+	if !serverinfoVersionPattern.MatchString(*serverinfoVersion) {
+		fmt.Printf("CRITICAL - Invalid --serverinfo-version %q (expected v followed by a number, e.g. v1)\n", *serverinfoVersion)
+		os.Exit(2)
+	}
+	paths := defaultAPIPaths(*serverinfoVersion)
+	if *apiPaths != "" {
+		if *serverinfoVersion != defaultServerinfoVersion {
+			fmt.Println("CRITICAL - --serverinfo-version cannot be combined with --api-path")
+			os.Exit(2)
+		}
+		paths = strings.Split(*apiPaths, ",")
+	}
+
+	var businessWindow *TimeWindow
+	if *businessHours != "" {
+		businessWindow, err = parseTimeWindow(*businessHours)
+		if err != nil {
+			fmt.Printf("CRITICAL - Invalid --business-hours: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	stealState := stateByName(*cpuStealState)
+	if stealState < 0 || stealState == StateUnknown {
+		fmt.Printf("CRITICAL - Invalid --cpu-steal-state %q (supported: ok, warning, critical)\n", *cpuStealState)
+		os.Exit(2)
+	}
+
+	grace, err := parseGracePeriod(*gracePeriod)
+	if err != nil {
+		fmt.Printf("CRITICAL - Invalid --grace-period: %v\n", err)
+		os.Exit(2)
+	}
+	if (grace.Warning > 1 || grace.Critical > 1) && *stateFile == "" {
+		fmt.Println("CRITICAL - --grace-period requires --state-file")
+		os.Exit(2)
+	}
+
+	if *http2Only && !strings.HasPrefix(strings.ToLower(*server), "https://") && *instancesFile == "" {
+		fmt.Println("CRITICAL - --http2-only requires an https server URL")
+		os.Exit(2)
+	}
+
+	var phpMemoryLimitBytes int64
+	if *phpMemoryLimit != "" {
+		phpMemoryLimitBytes, err = parsePHPSize(*phpMemoryLimit)
+		if err != nil {
+			fmt.Printf("CRITICAL - Invalid --php-memory-limit: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	webhookMin, err := webhookMinState(*webhookOn)
+	if err != nil {
+		fmt.Printf("CRITICAL - Invalid --webhook-on: %v\n", err)
+		os.Exit(2)
+	}
+	if *webhookURL != "" {
+		if u, err := url.Parse(*webhookURL); err != nil || u.Host == "" {
+			fmt.Printf("CRITICAL - Invalid --webhook-url %q\n", *webhookURL)
+			os.Exit(2)
+		}
+	}
+
+	if *cacheTTL < 0 {
+		fmt.Printf("CRITICAL - Invalid --cache-ttl: must not be negative, got %s\n", *cacheTTL)
+		os.Exit(2)
+	}
+
+	if *timeoutRetry != 0 && *timeoutRetry <= *timeout {
+		fmt.Printf("CRITICAL - Invalid --timeout-retry: must be longer than --timeout (%s), got %s\n", *timeout, *timeoutRetry)
+		os.Exit(2)
+	}
+
+	if *retries < 0 || *retryBackoff <= 0 {
+		fmt.Println("CRITICAL - --retries must not be negative and --retry-backoff must be positive")
+		os.Exit(2)
+	}
+
+	if *filesWindow < 24*time.Hour {
+		fmt.Printf("CRITICAL - Invalid --files-window: must be at least 24h, got %s\n", *filesWindow)
+		os.Exit(2)
+	}
+
+	if *proxy != "" {
+		if u, err := url.Parse(*proxy); err != nil || u.Host == "" {
+			fmt.Printf("CRITICAL - Invalid --proxy %q\n", *proxy)
+			os.Exit(2)
+		}
+	}
+	if *expectedBaseURL != "" {
+		if u, err := url.Parse(*expectedBaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			fmt.Printf("CRITICAL - Invalid --expected-base-url %q\n", *expectedBaseURL)
+			os.Exit(2)
+		}
+	}
+	if len(requiredApps) > 0 && (*appsUser == "" || *appsPassword == "") {
+		fmt.Println("CRITICAL - --check-app requires --apps-user and --apps-password")
+		os.Exit(2)
+	}
+
+	if *proxyUser != "" && *proxy == "" {
+		fmt.Println("CRITICAL - --proxy-user requires --proxy")
+		os.Exit(2)
+	}
+	if *proxyPassword != "" && *proxyUser == "" {
+		fmt.Println("CRITICAL - --proxy-password requires --proxy-user")
+		os.Exit(2)
+	}
+
+	if (*cfClientID == "") != (*cfClientSecret == "") {
+		fmt.Println("CRITICAL - --cf-client-id and --cf-client-secret must be given together")
+		os.Exit(2)
+	}
+
+	if *appUpdatesWarn < 0 {
+		fmt.Printf("CRITICAL - Invalid --updates-warn: must not be negative, got %d\n", *appUpdatesWarn)
+		os.Exit(2)
+	}
+
+	maintenanceStateValue := stateByName(*maintenanceState)
+	if maintenanceStateValue < 0 {
+		fmt.Printf("CRITICAL - Invalid --maintenance-state %q (supported: ok, warning, critical, unknown)\n", *maintenanceState)
+		os.Exit(2)
+	}
+
+	opcacheState := stateByName(*opcacheDisabledState)
+	if opcacheState < 0 || opcacheState == StateUnknown {
+		fmt.Printf("CRITICAL - Invalid --opcache-disabled-state %q (supported: ok, warning, critical)\n", *opcacheDisabledState)
+		os.Exit(2)
+	}
+
+	if err := validateMetricPrefix(*metricPrefix); err != nil {
+		fmt.Printf("CRITICAL - Invalid --metric-prefix: %v\n", err)
+		os.Exit(2)
+	}
+
+	if !isOutputFormat(*output) {
+		fmt.Printf("CRITICAL - Unknown --output %q (supported: %s)\n", *output, strings.Join(outputFormats, ", "))
+		os.Exit(2)
+	}
+
+	if *minVersion != "" {
+		if _, err := compareVersions(*minVersion, *minVersion); err != nil {
+			fmt.Printf("CRITICAL - Invalid --min-version: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	cfg := Config{
+		ServerURL:                *server,
+		Token:                    *token,
+		PerfdataFile:             *perfdataFile,
+		NoPerfdata:               *noPerfdata,
+		TalkHPBURL:               *talkHPBURL,
+		RequiredApps:             requiredApps,
+		AppsUser:                 *appsUser,
+		AppsPassword:             *appsPassword,
+		NormalizeVersion:         *normalizeVersionFlag,
+		AppCounts:                appCounts,
+		MinVersion:               *minVersion,
+		MinVersionCritical:       *minVersionCritical,
+		ExpectedEdition:          *expectedEdition,
+		UnixSocket:               *unixSocket,
+		Format:                   *format,
+		MemAvailable:             *memAvailable,
+		RequireSwap:              *requireSwap,
+		Mode:                     *mode,
+		Quiet:                    *quiet,
+		CPULoadWarn:              loadWarn,
+		CPUExpectedUsers:         *cpuExpectedUsers,
+		Output:                   *output,
+		ProbeFirst:               *probeFirst,
+		UserCap:                  *userCap,
+		UsersPercentWarn:         *usersPercentWarn,
+		UsersPercentCrit:         *usersPercentCrit,
+		UnconfiguredChecks:       *unconfiguredChecks,
+		CheckTrustedDomains:      *checkTrustedDomains,
+		Timeout:                  *timeout,
+		ConnectTimeout:           *connectTimeout,
+		TimeoutRetry:             *timeoutRetry,
+		Retries:                  *retries,
+		RetryBackoff:             *retryBackoff,
+		PerfdataFields:           perfdataFields,
+		Precision:                precision,
+		MaxSkew:                  *maxSkew,
+		Metric:                   *metric,
+		APIPaths:                 paths,
+		Debug:                    *debug,
+		StateFile:                *stateFile,
+		CheckDowngrade:           *checkDowngrade,
+		MemoryGrowthWarn:         *memoryGrowthWarn,
+		SecurityScanMinGrade:     *securityScanMinGrade,
+		SecurityScanMaxAge:       *securityScanMaxAge,
+		FailFast:                 *failFast,
+		Strict:                   *strict,
+		StatusFallback:           *statusFallback,
+		CheckUpgrade:             *checkUpgrade,
+		BaselineFile:             *baselineFile,
+		WriteBaseline:            *writeBaseline,
+		StatusPerfdata:           *statusPerfdata,
+		SummaryOnly:              *summaryOnly,
+		Production:               production,
+		MaxLogSize:               *maxLogSize,
+		AllowHTTP:                *allowHTTP,
+		RequireHTTPS:             *requireHTTPS,
+		ScoreWeights:             scoreWeights,
+		Tags:                     tags,
+		MetricPrefix:             *metricPrefix,
+		GraphitePrefix:           strings.Trim(*graphitePrefix, "."),
+		PHPMemoryLimit:           phpMemoryLimitBytes,
+		OpcacheDisabledState:     opcacheState,
+		MaintenanceState:         maintenanceStateValue,
+		AppUpdatesWarn:           *appUpdatesWarn,
+		SharesPerUserWarn:        *sharesPerUserWarn,
+		DBSlowQueryPercentWarn:   *dbSlowQueryPercentWarn,
+		ExpectedTimezone:         *expectedTimezone,
+		ExpectedLocale:           *expectedLocale,
+		PrimaryHost:              *primaryHost,
+		FilesWindow:              *filesWindow,
+		FilesLimit:               *filesLimit,
+		FilesHorizonDays:         *filesHorizonDays,
+		ExternalStorageWarn:      *externalStorageWarn,
+		ExternalStorageCrit:      *externalStorageCrit,
+		ObjectStorageURL:         *objectStorageURL,
+		ObjectStorageLatencyWarn: *objectStorageLatencyWarn,
+		Proxy:                    *proxy,
+		ProxyUser:                *proxyUser,
+		ProxyPassword:            *proxyPassword,
+		HostHeader:               *hostHeader,
+		PrometheusFile:           *prometheusFile,
+		OpcacheOOMRestartsWarn:   *opcacheOOMRestartsWarn,
+		OpcacheKeysPercentWarn:   *opcacheKeysPercentWarn,
+		OpcacheHitRateWarn:       *opcacheHitRateWarn,
+		OpcacheHitRateCrit:       *opcacheHitRateCrit,
+		GracePeriod:              grace,
+		HTTP2Only:                *http2Only,
+		FilesDropCrit:            *filesDropCrit,
+		CacheTTL:                 *cacheTTL,
+		CheckProxyHeaders:        *checkProxyHeaders,
+		CheckServerIdentity:      *checkServerIdentity,
+		ExpectedIdentity:         *expectedIdentity,
+		IdentityHeader:           *identityHeader,
+		CPUStealPercent:          *cpuStealPercent,
+		CPUStealState:            stealState,
+		BusinessHours:            businessWindow,
+		StoragesGrowthWarn:       *storagesGrowthWarn,
+		ExpectedBaseURL:          *expectedBaseURL,
+		CFClientID:               *cfClientID,
+		CFClientSecret:           *cfClientSecret,
+		OnlyMetrics:              *onlyMetrics,
+	}
+
+	start := time.Now()
+	target := *server
+	var result string
+	var exitCode int
+	var summary *HealthSummary
+	if instances != nil {
+		target = *instancesFile
+		result, exitCode = checkInstances(ctx, cfg, instances, *top)
+	} else if *compareWith != "" {
+		other := Instance{ServerURL: *compareWith, Token: *token2}
+		if other.Token == "" {
+			other.Token = *token
+		}
+		result, exitCode, err = compareInstances(ctx, cfg, other, *compareTolerance)
+	} else {
+		result, exitCode, summary, err = runCheck(ctx, cfg)
+	}
+
+	// The webhook is best effort and sent after the log record, its
+	// failure never changes the exit code. Runs that end before the checks
+	// are evaluated, failed runs, --instances-file and --compare-with get a
+	// summary with the status line only.
+	notify := func(state int, message string) {
+		if *webhookURL == "" {
+			return
+		}
+		client, err := newHTTPClient(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to send webhook: %v\n", err)
+			return
+		}
+		if summary == nil {
+			minimal := newHealthSummary(serverHost(target), "", statusLine(message), state, cfg.Tags, nil, nil, start)
+			summary = &minimal
+		}
+		sendWebhook(ctx, client, *webhookURL, *summary, webhookMin, cfg.Token)
+	}
+
+	if err != nil && ctx.Err() != nil {
+		err = &CancelledError{Err: err}
+	}
+	if err != nil {
+		message := fmt.Sprintf("%s - %v", stateNames[exitCodeForError(err)], err)
+		if *logFile != "" {
+			writeRunLog(*logFile, newRunRecord(target, exitCodeForError(err), exitMap[exitCodeForError(err)], message, start))
+		}
+		notify(exitCodeForError(err), message)
+		out := os.Stdout
+		if *quiet {
+			out = os.Stderr
+		}
+		fmt.Fprintln(out, message)
+		os.Exit(exitMap[exitCodeForError(err)])
+	}
+
+	if *logFile != "" {
+		writeRunLog(*logFile, newRunRecord(target, exitCode, exitMap[exitCode], result, start))
+	}
+	notify(exitCode, result)
+	if !*quiet {
+		fmt.Println(result)
+	}
+	os.Exit(exitMap[exitCode])
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readFixture returns the content of testdata/name.
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// newFixtureServer serves body on the serverinfo endpoint and a healthy
+// status.php, everything else is not found.
+func newFixtureServer(t *testing.T, body []byte) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case defaultAPIPaths(defaultServerinfoVersion)[0]:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body)
+		case "/status.php":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"installed":true,"maintenance":false,"needsDbUpgrade":false,"version":"30.0.4.1"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// testCheckConfig returns the configuration main builds from the default
+// flags for serverURL.
+func testCheckConfig(t *testing.T, serverURL string) Config {
+	t.Helper()
+	precision, err := parsePrecision("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testFetchConfig(serverURL)
+	cfg.Mode = "all"
+	cfg.Output = "nagios"
+	cfg.AllowHTTP = true
+	cfg.CPULoadWarn = [3]float64{5, 4, 3}
+	cfg.UnconfiguredChecks = "metrics-only"
+	cfg.Precision = precision
+	cfg.OpcacheDisabledState = StateWarning
+	cfg.UsersPercentWarn = 80
+	cfg.UsersPercentCrit = 90
+	cfg.FilesWindow = 7 * 24 * time.Hour
+	cfg.ExternalStorageWarn = 90
+	cfg.ExternalStorageCrit = 95
+	cfg.MetricPrefix = defaultMetricPrefix
+	cfg.GraphitePrefix = defaultGraphitePrefix
+	return cfg
+}
+
+// runFixtureCheck runs checkNextcloud against a server answering with
+// fixture, configure adjusts the default configuration first.
+func runFixtureCheck(t *testing.T, fixture []byte, configure func(*Config)) (string, int, error) {
+	t.Helper()
+	server := newFixtureServer(t, fixture)
+	cfg := testCheckConfig(t, server.URL)
+	if configure != nil {
+		configure(&cfg)
+	}
+	return checkNextcloud(context.Background(), cfg)
+}
+
+// splitPerfdata returns the perfdata part of a nagios status line.
+func splitPerfdata(t *testing.T, output string) string {
+	t.Helper()
+	firstLine, _, _ := strings.Cut(output, "\n")
+	_, perfdata, ok := strings.Cut(firstLine, " | ")
+	if !ok {
+		t.Fatalf("no perfdata in %q", output)
+	}
+	return perfdata
+}
+
+func TestCheckTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	cfg := testCheckConfig(t, server.URL)
+	cfg.Timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, _, err := checkNextcloud(context.Background(), cfg)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("check took %v with a %v timeout", elapsed, cfg.Timeout)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context deadline exceeded", err)
+	}
+	if got := exitCodeForError(err); got != StateCritical {
+		t.Errorf("exit state = %d, want %d", got, StateCritical)
+	}
+}
+
+// TestPlainHTTPFailedCheck checks that a failing check over plain HTTP
+// still warns that the token was sent in cleartext.
+func TestPlainHTTPFailedCheck(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	cfg := testCheckConfig(t, server.URL)
+	cfg.AllowHTTP = false
+
+	_, _, err := checkNextcloud(context.Background(), cfg)
+	if err == nil {
+		t.Fatal("check against a failing server succeeded")
+	}
+	if !strings.Contains(err.Error(), "Server URL Uses Plain HTTP") {
+		t.Errorf("err = %v, want the plain HTTP warning", err)
+	}
+	if got, want := exitCodeForError(err), exitCodeForError(errors.Unwrap(err)); got != want {
+		t.Errorf("exit state = %d, want %d of the unwrapped error", got, want)
+	}
+}
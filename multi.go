@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// instanceWorkers is the number of instances checked concurrently with
+// --instances-file.
+const instanceWorkers = 8
+
+// Instance is a server and token pair read from --instances-file.
+type Instance struct {
+	ServerURL string
+	Token     string
+}
+
+// InstanceResult is the outcome of checking a single instance.
+type InstanceResult struct {
+	Instance Instance
+	State    int
+	Message  string
+	// Breach is the largest ratio of a metric to its warning threshold,
+	// used to order instances of the same state.
+	Breach float64
+}
+
+// parseInstancesFile reads one "URL TOKEN" pair per line. Empty lines and
+// lines starting with # are ignored.
+func parseInstancesFile(path string) ([]Instance, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var instances []Instance
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected URL and token", line)
+		}
+		instances = append(instances, Instance{ServerURL: fields[0], Token: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no instances in %s", path)
+	}
+	return instances, nil
+}
+
+// severityRank orders states from best to worst. CRITICAL ranks above
+// UNKNOWN since it is a confirmed problem.
+var severityRank = [4]int{StateOK: 0, StateWarning: 1, StateUnknown: 2, StateCritical: 3}
+
+// perfdataBreach returns the largest value/warn ratio of the perfdata
+// entries with a plain numeric warning threshold, or 0 when there is none.
+func perfdataBreach(perfdata string) float64 {
+	breach := 0.0
+	for _, entry := range strings.Fields(perfdata) {
+		_, data, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+		parts := strings.Split(data, ";")
+		if len(parts) < 2 {
+			continue
+		}
+		value, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			continue
+		}
+		warn, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || warn <= 0 {
+			continue
+		}
+		if ratio := value / warn; ratio > breach {
+			breach = ratio
+		}
+	}
+	return breach
+}
+
+// checkInstances runs checkNextcloud against every instance and combines the
+// results. The exit code is that of the worst instance. The long output
+// lists the top worst instances (all when top is 0), ordered by state
+// (CRITICAL, UNKNOWN, WARNING, OK), then by the most breached metric
+// relative to its warning threshold, then by URL.
+func checkInstances(ctx context.Context, cfg Config, instances []Instance, top int) (string, int) {
+	results := make([]InstanceResult, len(instances))
+	sem := make(chan struct{}, instanceWorkers)
+	var wg sync.WaitGroup
+	for i, instance := range instances {
+		wg.Add(1)
+		go func(i int, instance Instance) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			instanceCfg := cfg
+			instanceCfg.ServerURL = instance.ServerURL
+			instanceCfg.Token = instance.Token
+			instanceCfg.NoPerfdata = false
+			instanceCfg.PerfdataFile = ""
+			instanceCfg.PrometheusFile = ""
+
+			result := InstanceResult{Instance: instance}
+			output, exitCode, err := checkNextcloud(ctx, instanceCfg)
+			if err != nil {
+				result.State = exitCodeForError(err)
+				result.Message = fmt.Sprintf("%s - %v", stateNames[result.State], err)
+			} else {
+				message, perfdata, _ := strings.Cut(output, " | ")
+				result.State, result.Message, result.Breach = exitCode, message, perfdataBreach(perfdata)
+			}
+			results[i] = result
+		}(i, instance)
+	}
+	wg.Wait()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if severityRank[a.State] != severityRank[b.State] {
+			return severityRank[a.State] > severityRank[b.State]
+		}
+		if a.Breach != b.Breach {
+			return a.Breach > b.Breach
+		}
+		return a.Instance.ServerURL < b.Instance.ServerURL
+	})
+
+	counts := [4]int{}
+	for _, result := range results {
+		counts[result.State]++
+	}
+	worst := results[0].State
+
+	summary := fmt.Sprintf("%s - %d of %d instances not OK (%d critical, %d warning, %d unknown)",
+		stateNames[worst], len(results)-counts[StateOK], len(results), counts[StateCritical], counts[StateWarning], counts[StateUnknown])
+	if !cfg.NoPerfdata {
+		summary += fmt.Sprintf(" | instances=%d instances_ok=%d instances_warning=%d instances_critical=%d instances_unknown=%d",
+			len(results), counts[StateOK], counts[StateWarning], counts[StateCritical], counts[StateUnknown])
+	}
+
+	lines := []string{summary}
+	shown := results
+	if top > 0 && top < len(results) {
+		shown = results[:top]
+	}
+	for _, result := range shown {
+		lines = append(lines, result.Instance.ServerURL+": "+result.Message)
+	}
+	if len(shown) < len(results) {
+		lines = append(lines, fmt.Sprintf("... %d more instances not shown", len(results)-len(shown)))
+	}
+
+	return strings.Join(lines, "\n"), worst
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// probeObjectStorage sends a HEAD request to the object storage endpoint or
+// bucket URL and returns the response latency. Unauthenticated requests are
+// usually answered with 403, so any status below 500 counts as reachable.
+func probeObjectStorage(ctx context.Context, client *http.Client, endpoint string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "check_nextcloud/1.0")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	latency := time.Since(start)
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return latency, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return latency, nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// outputFormats lists the supported values of --output.
+var outputFormats = []string{"nagios", "influx", "json", "score", "prometheus", "openmetrics", "graphite"}
+
+func isOutputFormat(name string) bool {
+	for _, format := range outputFormats {
+		if format == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Tags holds the static --tag key=value pairs attached to structured output.
+// It implements flag.Value so --tag can be repeated.
+type Tags map[string]string
+
+var tagKeyPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func (t Tags) String() string {
+	pairs := make([]string, 0, len(t))
+	for _, key := range t.keys() {
+		pairs = append(pairs, key+"="+t[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set validates and adds a key=value pair. Keys must be valid Prometheus
+// label names, the most restrictive of the supported formats, and must not
+// shadow the built-in host and version tags.
+func (t Tags) Set(pair string) error {
+	key, value, ok := strings.Cut(pair, "=")
+	if !ok || value == "" {
+		return fmt.Errorf("expected key=value, got %q", pair)
+	}
+	if !tagKeyPattern.MatchString(key) || strings.HasPrefix(key, "__") {
+		return fmt.Errorf("invalid tag key %q", key)
+	}
+	if key == "host" || key == "version" {
+		return fmt.Errorf("tag key %q is reserved", key)
+	}
+	t[key] = value
+	return nil
+}
+
+func (t Tags) keys() []string {
+	keys := make([]string, 0, len(t))
+	for key := range t {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedKeys returns the metric names in a stable order.
+func sortedKeys(metrics map[string]interface{}) []string {
+	keys := make([]string, 0, len(metrics))
+	for key := range metrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// serverHost returns the host part of the server URL for use as an
+// instance identifier in structured output.
+func serverHost(serverURL string) string {
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Host == "" {
+		return serverURL
+	}
+	return u.Host
+}
+
+// defaultMetricPrefix is the default --metric-prefix.
+const defaultMetricPrefix = "nextcloud_"
+
+var metricPrefixPattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// validateMetricPrefix checks --metric-prefix against the Prometheus metric
+// naming rules. An empty prefix is allowed since the metric keys are valid
+// names by themselves.
+func validateMetricPrefix(prefix string) error {
+	if prefix != "" && !metricPrefixPattern.MatchString(prefix) {
+		return fmt.Errorf("%q is not a valid Prometheus metric name prefix", prefix)
+	}
+	return nil
+}
+
+// influxMeasurement derives the line-protocol measurement from the metric
+// prefix, so the default prefix keeps the nextcloud measurement.
+func influxMeasurement(prefix string) string {
+	if measurement := strings.TrimRight(prefix, "_:"); measurement != "" {
+		return measurement
+	}
+	return strings.TrimSuffix(defaultMetricPrefix, "_")
+}
+
+var influxTagEscaper = strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ")
+
+// formatInflux renders the metrics as a single InfluxDB line-protocol record:
+//
+//	<measurement>,host=<host>,version=<version>[,<tag>=<value>...] <field>=<value>,... <timestamp>
+//
+// The field keys are the plain metric names, the measurement namespaces them.
+// Integer metrics are written with the "i" suffix, the timestamp is in
+// nanoseconds.
+func formatInflux(measurement, host, version string, tags Tags, metrics map[string]interface{}, ts time.Time) string {
+	tagSet := "host=" + influxTagEscaper.Replace(host) + ",version=" + influxTagEscaper.Replace(version)
+	for _, key := range tags.keys() {
+		tagSet += "," + key + "=" + influxTagEscaper.Replace(tags[key])
+	}
+
+	fields := make([]string, 0, len(metrics))
+	for _, key := range sortedKeys(metrics) {
+		switch value := metrics[key].(type) {
+		case int:
+			fields = append(fields, fmt.Sprintf("%s=%di", key, value))
+		case int64:
+			fields = append(fields, fmt.Sprintf("%s=%di", key, value))
+		case float64:
+			fields = append(fields, fmt.Sprintf("%s=%v", key, value))
+		}
+	}
+
+	return fmt.Sprintf("%s,%s %s %d", measurement, tagSet, strings.Join(fields, ","), ts.UnixNano())
+}
+
+// defaultGraphitePrefix is the default --graphite-prefix, {host} is
+// replaced by the instance host.
+const defaultGraphitePrefix = "nextcloud.{host}"
+
+// graphiteNodeEscaper replaces the characters that would split or break a
+// node of a Graphite metric path.
+var graphiteNodeEscaper = strings.NewReplacer(".", "_", ":", "_", " ", "_", "/", "_")
+
+// formatGraphite renders the numeric metrics in the Graphite plaintext
+// protocol, one "<prefix>.<metric> <value> <timestamp>" line per metric.
+// {host} in prefix is replaced by the host with dots and colons turned into
+// underscores, so it stays a single path node.
+func formatGraphite(prefix, host string, metrics map[string]interface{}, ts time.Time) string {
+	prefix = strings.ReplaceAll(prefix, "{host}", graphiteNodeEscaper.Replace(host))
+	if prefix != "" {
+		prefix += "."
+	}
+
+	lines := make([]string, 0, len(metrics))
+	for _, key := range sortedKeys(metrics) {
+		if _, isString := metrics[key].(string); isString {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s%s %v %d", prefix, key, metrics[key], ts.Unix()))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// healthSchemaVersion is the schema_version of the --output json document.
+// Bump it only on breaking changes (renamed or removed keys, changed types);
+// adding metrics is not a breaking change.
+const healthSchemaVersion = 1
+
+// HealthSummary is the --output json document (schema version 1):
+//
+//	schema_version  int     version of this schema
+//	host            string  host of the monitored instance
+//	version         string  Nextcloud version
+//	state           string  OK, WARNING, CRITICAL or UNKNOWN
+//	exit_code       int     plugin exit code before --exit-map
+//	message         string  human readable status line without perfdata
+//	timestamp       int     unix time of the check
+//	metrics         object  numeric metrics keyed by perfdata name
+//	tags            object  --tag pairs, omitted when none are set
+//	breaches        array   conditions that raised the state (metric,
+//	                        value, threshold, severity, message), omitted
+//	                        when none did
+type HealthSummary struct {
+	SchemaVersion int                    `json:"schema_version"`
+	Host          string                 `json:"host"`
+	Version       string                 `json:"version"`
+	State         string                 `json:"state"`
+	ExitCode      int                    `json:"exit_code"`
+	Message       string                 `json:"message"`
+	Timestamp     int64                  `json:"timestamp"`
+	Metrics       map[string]interface{} `json:"metrics"`
+	Tags          Tags                   `json:"tags,omitempty"`
+	Breaches      []Breach               `json:"breaches,omitempty"`
+}
+
+// newHealthSummary builds the HealthSummary of a check result. Only numeric
+// metrics are included.
+func newHealthSummary(host, version, message string, exitCode int, tags Tags, metrics map[string]interface{}, breaches []Breach, ts time.Time) HealthSummary {
+	summary := HealthSummary{
+		SchemaVersion: healthSchemaVersion,
+		Host:          host,
+		Version:       version,
+		State:         stateNames[exitCode],
+		ExitCode:      exitCode,
+		Message:       message,
+		Timestamp:     ts.Unix(),
+		Metrics:       map[string]interface{}{},
+		Tags:          tags,
+		Breaches:      breaches,
+	}
+	for key, value := range metrics {
+		if _, isString := value.(string); !isString {
+			summary.Metrics[key] = value
+		}
+	}
+	return summary
+}
+
+// formatJSON renders summary as the --output json document.
+func formatJSON(summary HealthSummary) (string, error) {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+var prometheusLabelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+// openMetricsUnits maps metric name suffixes to OpenMetrics units. A unit
+// must be the suffix of the metric name, so only these get a # UNIT line.
+var openMetricsUnits = []string{"bytes", "seconds"}
+
+// formatPrometheus renders the numeric metrics in the Prometheus text
+// exposition format as gauges named <prefix><metric>, labelled with host,
+// version and the --tag pairs. With openMetrics the output follows the
+// OpenMetrics text format instead: metrics with a unit suffix get a # UNIT
+// line and the exposition ends with # EOF.
+func formatPrometheus(prefix, host, version string, tags Tags, metrics map[string]interface{}, openMetrics bool) string {
+	labels := fmt.Sprintf(`host="%s",version="%s"`, prometheusLabelEscaper.Replace(host), prometheusLabelEscaper.Replace(version))
+	for _, key := range tags.keys() {
+		labels += fmt.Sprintf(`,%s="%s"`, key, prometheusLabelEscaper.Replace(tags[key]))
+	}
+
+	var b strings.Builder
+	for _, key := range sortedKeys(metrics) {
+		if _, isString := metrics[key].(string); isString {
+			continue
+		}
+		name := prefix + key
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", name)
+		if openMetrics {
+			for _, unit := range openMetricsUnits {
+				if strings.HasSuffix(name, "_"+unit) {
+					fmt.Fprintf(&b, "# UNIT %s %s\n", name, unit)
+				}
+			}
+		}
+		fmt.Fprintf(&b, "%s{%s} %v\n", name, labels, metrics[key])
+	}
+	if openMetrics {
+		b.WriteString("# EOF")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// writePrometheusFile writes the Prometheus exposition atomically, so a
+// node_exporter textfile collector never reads a partial file. Failures are
+// reported on stderr only and never change the check result.
+func writePrometheusFile(path, exposition string) {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(exposition+"\n"), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write prometheus file: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write prometheus file: %v\n", err)
+	}
+}
+
+// StorageRow is one line of the storage breakdown in the long output. Total
+// is 0 when unknown.
+type StorageRow struct {
+	Name  string
+	Free  int64
+	Total int64
+}
+
+// formatStorageTable renders the storages as an aligned table for the Nagios
+// long output, so the full mount is visible at a glance.
+func formatStorageTable(rows []StorageRow) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STORAGE\tFREE\tTOTAL\tUSED")
+	for _, row := range rows {
+		total, used := "-", "-"
+		if row.Total > 0 {
+			total = formatBytes(row.Total)
+			used = fmt.Sprintf("%.1f%%", float64(row.Total-row.Free)/float64(row.Total)*100)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", row.Name, formatBytes(row.Free), total, used)
+	}
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. 1.5 GiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, exp := float64(n)/unit, 0
+	for value >= unit && exp < 4 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exp])
+}
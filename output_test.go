@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatJSONKeys(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	metrics := map[string]interface{}{"num_users": 12, "memory_usage_percent": 16.7, "version": "30.0.4.1"}
+
+	tests := []struct {
+		name     string
+		tags     Tags
+		breaches []Breach
+		want     []string
+	}{
+		{
+			name: "minimal",
+			want: []string{"exit_code", "host", "message", "metrics", "schema_version", "state", "timestamp", "version"},
+		},
+		{
+			name:     "tags and breaches",
+			tags:     Tags{"env": "prod"},
+			breaches: []Breach{{Metric: "memory_usage_percent", Value: 95.0, Threshold: "90", Severity: "CRITICAL"}},
+			want:     []string{"breaches", "exit_code", "host", "message", "metrics", "schema_version", "state", "tags", "timestamp", "version"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := formatJSON(newHealthSummary("cloud.example.com", "30.0.4.1", "OK - Nextcloud 30.0.4.1 running.", StateOK, tt.tags, metrics, tt.breaches, ts))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var doc map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(output), &doc); err != nil {
+				t.Fatalf("invalid json %q: %v", output, err)
+			}
+			keys := make([]string, 0, len(doc))
+			for key := range doc {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("keys = %v, want %v", keys, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatJSONValues(t *testing.T) {
+	metrics := map[string]interface{}{"num_users": 12, "version": "30.0.4.1"}
+	output, err := formatJSON(newHealthSummary("cloud.example.com", "30.0.4.1", "WARNING - x", StateWarning, nil, metrics, nil, time.Unix(1700000000, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"schema_version":1,"host":"cloud.example.com","version":"30.0.4.1","state":"WARNING","exit_code":1,"message":"WARNING - x","timestamp":1700000000,"metrics":{"num_users":12}}`
+	if output != want {
+		t.Errorf("formatJSON() = %s, want %s", output, want)
+	}
+}
+
+func TestFormatJSONBreaches(t *testing.T) {
+	breachingConfig := func(cfg *Config) {
+		cfg.Output = "json"
+		cfg.CPULoadWarn = [3]float64{0.5, 4, 3}
+		cfg.MinVersion = "31.0.0"
+		cfg.DBSlowQueryPercentWarn = 0.5
+		cfg.UserCap = 13
+	}
+	cpuLoad := map[string]interface{}{"metric": "cpu_load_1m", "value": 0.57, "threshold": "0.5", "severity": "WARNING", "message": "High CPU Load"}
+	minVersion := map[string]interface{}{"metric": "min_version", "threshold": "31.0.0", "severity": "WARNING", "message": "Nextcloud 30.0.4.1 below minimum version 31.0.0"}
+	slowQueries := map[string]interface{}{"metric": "db_slow_query_percent", "value": 0.75, "threshold": "0.5", "severity": "WARNING", "message": "0.8% Slow Database Queries"}
+	userCap := map[string]interface{}{"metric": "num_users_percent", "value": 92.31, "threshold": "90", "severity": "CRITICAL", "message": "User Cap Nearly Reached"}
+
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		wantState string
+		want      []map[string]interface{}
+	}{
+		{
+			name:      "all breaches",
+			configure: func(cfg *Config) {},
+			wantState: "CRITICAL",
+			want:      []map[string]interface{}{cpuLoad, minVersion, slowQueries, userCap},
+		},
+		{
+			name:      "grace period",
+			configure: func(cfg *Config) { cfg.GracePeriod = GracePeriod{Warning: 3, Critical: 3} },
+			wantState: "CRITICAL",
+			want:      []map[string]interface{}{minVersion, userCap},
+		},
+		{
+			name:      "only metrics",
+			configure: func(cfg *Config) { cfg.OnlyMetrics = true },
+			wantState: "OK",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, exitCode, err := runFixtureCheck(t, readFixture(t, "serverinfo.json"), func(cfg *Config) {
+				breachingConfig(cfg)
+				tt.configure(cfg)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var doc struct {
+				State    string                   `json:"state"`
+				ExitCode int                      `json:"exit_code"`
+				Breaches []map[string]interface{} `json:"breaches"`
+			}
+			if err := json.Unmarshal([]byte(output), &doc); err != nil {
+				t.Fatalf("invalid json %q: %v", output, err)
+			}
+			if doc.State != tt.wantState || doc.ExitCode != exitCode {
+				t.Errorf("state = %s (exit_code %d), want %s (exit code %d)", doc.State, doc.ExitCode, tt.wantState, exitCode)
+			}
+			if !reflect.DeepEqual(doc.Breaches, tt.want) {
+				t.Errorf("breaches = %v, want %v", doc.Breaches, tt.want)
+			}
+		})
+	}
+}
+
+// TestOpcacheDisabledBreach checks that a disabled opcache is listed in the
+// breaches even when an earlier check already raised the state above it.
+func TestOpcacheDisabledBreach(t *testing.T) {
+	fixture := bytes.Replace(readFixture(t, "serverinfo.json"), []byte(`"opcache_enabled": true`), []byte(`"opcache_enabled": false`), 1)
+	output, _, err := runFixtureCheck(t, fixture, func(cfg *Config) {
+		cfg.Output = "json"
+		cfg.AllowHTTP = false
+		cfg.RequireHTTPS = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		State    string   `json:"state"`
+		Breaches []Breach `json:"breaches"`
+	}
+	if err := json.Unmarshal([]byte(output), &doc); err != nil {
+		t.Fatalf("invalid json %q: %v", output, err)
+	}
+	want := []Breach{
+		{Metric: "https", Severity: "CRITICAL", Message: "Server URL Uses Plain HTTP"},
+		{Metric: "opcache", Severity: "WARNING", Message: "PHP Opcache Disabled"},
+	}
+	if doc.State != "CRITICAL" || !reflect.DeepEqual(doc.Breaches, want) {
+		t.Errorf("state = %s, breaches = %+v, want CRITICAL with %+v", doc.State, doc.Breaches, want)
+	}
+}
+
+// TestSummaryOnlyStructuredOutput verifies that --summary-only does not cut
+// the score and json documents short.
+func TestSummaryOnlyStructuredOutput(t *testing.T) {
+	tests := []struct {
+		output string
+		prefix string
+	}{
+		{"json", `{"schema_version":1,`},
+		{"score", "OK - Nextcloud health score "},
+		{"nagios", "OK | "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			output, _, err := runFixtureCheck(t, readFixture(t, "serverinfo.json"), func(cfg *Config) {
+				cfg.SummaryOnly = true
+				cfg.Output = tt.output
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(output, tt.prefix) {
+				t.Errorf("output %q does not start with %q", output, tt.prefix)
+			}
+		})
+	}
+}
+
+var (
+	openMetricsType   = regexp.MustCompile(`^# TYPE ([a-zA-Z_:][a-zA-Z0-9_:]*) gauge$`)
+	openMetricsUnit   = regexp.MustCompile(`^# UNIT ([a-zA-Z_:][a-zA-Z0-9_:]*) ([a-z]+)$`)
+	openMetricsSample = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)\{([a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\.)*",?)*\} -?[0-9.e+]+$`)
+)
+
+func TestFormatPrometheusOpenMetrics(t *testing.T) {
+	metrics := map[string]interface{}{
+		"response_bytes":       2288,
+		"clock_skew_seconds":   -1.0,
+		"memory_usage_percent": 16.7,
+		"version":              "30.0.4.1",
+	}
+	output := formatPrometheus(defaultMetricPrefix, "cloud.example.com", `30.0.4.1 "beta"`, Tags{"env": "prod"}, metrics, true)
+
+	lines := strings.Split(output, "\n")
+	if last := lines[len(lines)-1]; last != "# EOF" {
+		t.Fatalf("exposition ends with %q, want # EOF", last)
+	}
+
+	// Every family is declared by # TYPE, optionally followed by a # UNIT
+	// matching the name suffix, before its sample.
+	family := ""
+	units := map[string]string{}
+	for i, line := range lines[:len(lines)-1] {
+		switch {
+		case line == "# EOF":
+			t.Fatalf("line %d: # EOF before the end", i+1)
+		case openMetricsType.MatchString(line):
+			family = openMetricsType.FindStringSubmatch(line)[1]
+		case openMetricsUnit.MatchString(line):
+			m := openMetricsUnit.FindStringSubmatch(line)
+			if m[1] != family || !strings.HasSuffix(m[1], "_"+m[2]) {
+				t.Errorf("line %d: unit %q does not match family %q", i+1, line, family)
+			}
+			units[m[1]] = m[2]
+		case openMetricsSample.MatchString(line):
+			if name := openMetricsSample.FindStringSubmatch(line)[1]; name != family {
+				t.Errorf("line %d: sample %q outside its family %q", i+1, name, family)
+			}
+		default:
+			t.Errorf("line %d: invalid OpenMetrics line %q", i+1, line)
+		}
+	}
+
+	wantUnits := map[string]string{"nextcloud_response_bytes": "bytes", "nextcloud_clock_skew_seconds": "seconds"}
+	if !reflect.DeepEqual(units, wantUnits) {
+		t.Errorf("units = %v, want %v", units, wantUnits)
+	}
+	if strings.Contains(output, "nextcloud_version") {
+		t.Error("string metric version exported as a sample")
+	}
+	if !strings.Contains(output, `version="30.0.4.1 \"beta\""`) {
+		t.Errorf("version label not escaped: %s", output)
+	}
+}
+
+func TestFormatPrometheusText(t *testing.T) {
+	metrics := map[string]interface{}{"response_bytes": 2288, "num_users": 12}
+	want := `# TYPE nextcloud_num_users gauge
+nextcloud_num_users{host="cloud.example.com",version="30.0.4.1"} 12
+# TYPE nextcloud_response_bytes gauge
+nextcloud_response_bytes{host="cloud.example.com",version="30.0.4.1"} 2288`
+
+	if got := formatPrometheus(defaultMetricPrefix, "cloud.example.com", "30.0.4.1", nil, metrics, false); got != want {
+		t.Errorf("formatPrometheus() =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// perfdataMetrics lists every metric key checkNextcloud may emit. Keep it in
+// sync when adding metrics, it is used to validate --perfdata-fields.
+var perfdataMetrics = []string{
+	"version", "nagios_status", "response_bytes",
+	"num_users", "num_users_percent", "num_files", "num_shares",
+	"num_storages", "num_storages_delta",
+	"num_files_change_percent", "num_files_per_day", "num_files_days_until_limit", "shares_per_user",
+	"cpu_load_1m", "cpu_load_5m", "cpu_load_15m", "ncpu",
+	"cpu_steal_percent", "cpu_iowait_percent",
+	"memory_total", "memory_free", "memory_usage_percent", "memory_usage_delta",
+	"swap_total", "swap_free", "swap_usage_percent",
+	"num_apps_installed", "num_apps_update_available",
+	"active_users_5m", "active_users_1h", "active_users_24h", "active_users_7d",
+	"active_users_1mo", "active_users_3mo", "active_users_6mo", "active_users_1y",
+	"opcache_hit_rate", "opcache_oom_restarts", "opcache_hash_restarts", "opcache_manual_restarts",
+	"opcache_oom_restart_rate", "opcache_cached_scripts", "opcache_cached_keys_percent",
+	"php_opcache_memory_percent", "php_interned_strings_percent", "clock_skew_seconds", "logfile_size_bytes",
+	"object_storage_latency_ms", "external_storage_usage_percent",
+	"db_queries", "db_slow_queries", "db_slow_query_percent",
+	"db_pending_migrations",
+}
+
+// isPerfdataMetric also accepts the app_ keys of --app-count, whose names
+// are configured at runtime.
+func isPerfdataMetric(name string) bool {
+	if strings.HasPrefix(name, appMetricPrefix) && appCountNamePattern.MatchString(strings.TrimPrefix(name, appMetricPrefix)) {
+		return true
+	}
+	for _, metric := range perfdataMetrics {
+		if metric == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePerfdataFields parses the comma-separated --perfdata-fields allowlist.
+// An empty spec allows all metrics and returns nil.
+func parsePerfdataFields(spec string) (map[string]bool, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	fields := map[string]bool{}
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if !isPerfdataMetric(field) {
+			return nil, fmt.Errorf("unknown metric %q", field)
+		}
+		fields[field] = true
+	}
+
+	return fields, nil
+}
+
+// metricTypes lists the metric types in --precision order.
+var metricTypes = []string{"percent", "load", "rate", "bytes"}
+
+// metricType classifies a metric key by its naming convention. New metrics
+// pick up the precision of their type by following it: _percent and _delta
+// for percentages, cpu_load_ for load averages, _rate for hit rates and
+// _bytes, _total and _free for byte counts. Other metrics are not rounded.
+func metricType(key string) string {
+	switch {
+	case strings.HasSuffix(key, "_percent") || strings.HasSuffix(key, "_delta"):
+		return "percent"
+	case strings.HasPrefix(key, "cpu_load_"):
+		return "load"
+	case strings.HasSuffix(key, "_rate"):
+		return "rate"
+	case strings.HasSuffix(key, "_bytes") || strings.HasSuffix(key, "_total") || strings.HasSuffix(key, "_free"):
+		return "bytes"
+	}
+	return ""
+}
+
+// parsePrecision parses a --precision spec like "percent=2,load=3" into the
+// number of decimal digits per metric type. Types not mentioned keep their
+// default; -1 disables rounding for a type.
+func parsePrecision(spec string) (map[string]int, error) {
+	precision := map[string]int{"percent": 1, "load": 2, "rate": 2, "bytes": 0}
+	if spec == "" {
+		return precision, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected type=digits, got %q", pair)
+		}
+		name = strings.TrimSpace(name)
+		if _, known := precision[name]; !known {
+			return nil, fmt.Errorf("unknown metric type %q (supported: %s)", name, strings.Join(metricTypes, ", "))
+		}
+		digits, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || digits < -1 || digits > 15 {
+			return nil, fmt.Errorf("invalid digits %q", value)
+		}
+		precision[name] = digits
+	}
+
+	return precision, nil
+}
+
+// formatValue renders a metric value, rounding floats to the precision of
+// their metric type. Trailing zeros are dropped.
+func formatValue(key string, value interface{}, precision map[string]int) string {
+	if s, isString := value.(string); isString {
+		return sanitizePerfdataValue(s)
+	}
+	f, ok := value.(float64)
+	if !ok {
+		return fmt.Sprintf("%v", value)
+	}
+	if digits, ok := precision[metricType(key)]; ok && digits >= 0 {
+		scale := math.Pow(10, float64(digits))
+		f = math.Round(f*scale) / scale
+	}
+	// Rounding small negative values yields -0, which reads as a sign flip.
+	if f == 0 {
+		f = 0
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// PerfThreshold holds the warn and crit fields of a perfdata entry in Nagios
+// range syntax. Empty fields are left out.
+type PerfThreshold struct {
+	Warn string
+	Crit string
+}
+
+// unsafePerfdataValue matches the characters replaced in string values. A
+// space, = or | from the server would otherwise split or end the perfdata.
+var unsafePerfdataValue = regexp.MustCompile(`[^A-Za-z0-9._+~-]`)
+
+func sanitizePerfdataValue(value string) string {
+	return unsafePerfdataValue.ReplaceAllString(value, "_")
+}
+
+// perfdataLabel single-quotes labels containing spaces, = or quotes following
+// the Nagios plugin guidelines, a quote inside is doubled.
+func perfdataLabel(label string) string {
+	if !strings.ContainsAny(label, " ='") {
+		return label
+	}
+	return "'" + strings.ReplaceAll(label, "'", "''") + "'"
+}
+
+// pluginText replaces | in the human readable output, where it would start
+// the perfdata section.
+func pluginText(text string) string {
+	return strings.ReplaceAll(text, "|", "/")
+}
+
+func formatThreshold(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// formatPerfdata renders metrics as Nagios/Icinga2 performance data.
+//
+// The output contract is:
+//   - entries are separated by a single space and sorted by label
+//   - each entry is label=value[;warn[;crit]]; labels with spaces, = or
+//     quotes are single-quoted and string values are reduced to
+//     [A-Za-z0-9._+~-], other characters become _
+//   - warn and crit are only present for metrics with an active threshold and
+//     use the same values the check evaluates, so Icinga can recolor graphs
+//     by itself; a missing warn with a present crit is written as label=value;;crit
+//
+// Float values are rounded according to precision, see formatValue. When
+// fields is non-nil only the listed metrics are rendered.
+func formatPerfdata(metrics map[string]interface{}, thresholds map[string]PerfThreshold, fields map[string]bool, precision map[string]int) string {
+	entries := make([]string, 0, len(metrics))
+	for _, key := range sortedKeys(metrics) {
+		if fields != nil && !fields[key] {
+			continue
+		}
+		entry := perfdataLabel(key) + "=" + formatValue(key, metrics[key], precision)
+		if threshold, ok := thresholds[key]; ok {
+			entry += ";" + threshold.Warn
+			if threshold.Crit != "" {
+				entry += ";" + threshold.Crit
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return strings.Join(entries, " ")
+}
+
+// writePerfdataFile appends a timestamped perfdata line to path. Failures are
+// reported on stderr only so that metric collection never changes the check result.
+func writePerfdataFile(path string, perfdata string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open perfdata file: %v\n", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintf(f, "%d\t%s\n", time.Now().Unix(), perfdata); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write perfdata file: %v\n", err)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares got with testdata/name, or rewrites it with -update.
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile("testdata/"+name, []byte(got+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want := strings.TrimSuffix(string(readFixture(t, name)), "\n")
+	if got != want {
+		t.Errorf("output differs from testdata/%s\ngot:  %s\nwant: %s", name, got, want)
+	}
+}
+
+func TestPerfdataGolden(t *testing.T) {
+	output, exitCode, err := runFixtureCheck(t, readFixture(t, "serverinfo.json"), func(cfg *Config) {
+		cfg.UserCap = 20
+		cfg.DBSlowQueryPercentWarn = 5
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCode != StateOK {
+		t.Fatalf("exit code = %d, want %d: %s", exitCode, StateOK, output)
+	}
+	// The clock skew depends on the time the test runs.
+	var entries []string
+	for _, entry := range strings.Fields(splitPerfdata(t, output)) {
+		if !strings.HasPrefix(entry, "clock_skew_seconds=") {
+			entries = append(entries, entry)
+		}
+	}
+	checkGolden(t, "perfdata.golden", strings.Join(entries, " "))
+}
+
+// TestPerfdataThresholds verifies that the perfdata thresholds follow the
+// configured values the check evaluates.
+func TestPerfdataThresholds(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		want      []string
+		absent    []string
+	}{
+		{
+			name:   "defaults",
+			want:   []string{"cpu_load_1m=0.57;5", "cpu_load_5m=0.38;4", "cpu_load_15m=0.35;3", "memory_usage_percent=16.7;80;90", "swap_usage_percent=0;80;90", "num_apps_update_available=0;0"},
+			absent: []string{"num_users_percent="},
+		},
+		{
+			name:      "cpu load",
+			configure: func(cfg *Config) { cfg.CPULoadWarn = [3]float64{8, 6.5, 4} },
+			want:      []string{"cpu_load_1m=0.57;8", "cpu_load_5m=0.38;6.5", "cpu_load_15m=0.35;4"},
+		},
+		{
+			name:      "cpu load with expected users",
+			configure: func(cfg *Config) { cfg.CPUExpectedUsers = 3 },
+			want:      []string{"cpu_load_1m=0.57 "},
+		},
+		{
+			name: "user cap",
+			configure: func(cfg *Config) {
+				cfg.UserCap = 40
+				cfg.UsersPercentWarn = 70
+				cfg.UsersPercentCrit = 85
+			},
+			want: []string{"num_users_percent=30;70;85"},
+		},
+		{
+			name:      "shares per user",
+			configure: func(cfg *Config) { cfg.SharesPerUserWarn = 2 },
+			want:      []string{"shares_per_user=0.25;2"},
+		},
+		{
+			name:      "updates",
+			configure: func(cfg *Config) { cfg.AppUpdatesWarn = 3 },
+			want:      []string{"num_apps_update_available=0;3"},
+		},
+		{
+			name:      "only metrics",
+			configure: func(cfg *Config) { cfg.OnlyMetrics = true },
+			want:      []string{"cpu_load_1m=0.57 ", "memory_usage_percent=16.7 "},
+		},
+		{
+			name:      "single mode",
+			configure: func(cfg *Config) { cfg.Mode = "memory" },
+			want:      []string{"memory_usage_percent=16.7;80;90", "cpu_load_1m=0.57 "},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, _, err := runFixtureCheck(t, readFixture(t, "serverinfo.json"), tt.configure)
+			if err != nil {
+				t.Fatal(err)
+			}
+			perfdata := splitPerfdata(t, output) + " "
+			for _, entry := range tt.want {
+				if !strings.Contains(perfdata, entry) {
+					t.Errorf("perfdata lacks %q: %s", entry, perfdata)
+				}
+			}
+			for _, entry := range tt.absent {
+				if strings.Contains(perfdata, entry) {
+					t.Errorf("perfdata has %q: %s", entry, perfdata)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatPerfdata(t *testing.T) {
+	precision, _ := parsePrecision("")
+	tests := []struct {
+		name       string
+		metrics    map[string]interface{}
+		thresholds map[string]PerfThreshold
+		fields     map[string]bool
+		want       string
+	}{
+		{
+			name:    "sorted without thresholds",
+			metrics: map[string]interface{}{"num_users": 12, "cpu_load_1m": 0.57, "memory_free": int64(1024)},
+			want:    "cpu_load_1m=0.57 memory_free=1024 num_users=12",
+		},
+		{
+			name:       "warn and crit",
+			metrics:    map[string]interface{}{"memory_usage_percent": 85.0},
+			thresholds: map[string]PerfThreshold{"memory_usage_percent": {Warn: "80", Crit: "90"}},
+			want:       "memory_usage_percent=85;80;90",
+		},
+		{
+			name:       "crit without warn",
+			metrics:    map[string]interface{}{"num_files_change_percent": -12.5},
+			thresholds: map[string]PerfThreshold{"num_files_change_percent": {Crit: "-10:"}},
+			want:       "num_files_change_percent=-12.5;;-10:",
+		},
+		{
+			name:       "fields filter",
+			metrics:    map[string]interface{}{"num_users": 12, "num_files": 3},
+			thresholds: map[string]PerfThreshold{"num_files": {Warn: "1"}},
+			fields:     map[string]bool{"num_users": true},
+			want:       "num_users=12",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatPerfdata(tt.metrics, tt.thresholds, tt.fields, precision); got != tt.want {
+				t.Errorf("formatPerfdata() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrecisionGolden(t *testing.T) {
+	metrics := map[string]interface{}{
+		"memory_usage_percent": 16.66666,
+		"memory_usage_delta":   -0.04,
+		"cpu_load_1m":          0.574219,
+		"opcache_hit_rate":     96.24789,
+		"memory_total":         65643520.7,
+		"shares_per_user":      0.333333,
+		"num_users":            12,
+		"version":              "30.0.4.1",
+	}
+
+	tests := []struct {
+		spec   string
+		golden string
+	}{
+		{"", "precision_default.golden"},
+		{"percent=2,load=3,rate=0,bytes=-1", "precision_custom.golden"},
+		{"percent=-1,load=-1,rate=-1,bytes=-1", "precision_none.golden"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			precision, err := parsePrecision(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, tt.golden, formatPerfdata(metrics, nil, nil, precision))
+		})
+	}
+}
+
+func TestParsePrecision(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]int
+		wantErr bool
+	}{
+		{"", map[string]int{"percent": 1, "load": 2, "rate": 2, "bytes": 0}, false},
+		{"percent=3", map[string]int{"percent": 3, "load": 2, "rate": 2, "bytes": 0}, false},
+		{" load = -1 ,bytes=2", map[string]int{"percent": 1, "load": -1, "rate": 2, "bytes": 2}, false},
+		{"percent", nil, true},
+		{"temperature=1", nil, true},
+		{"rate=-2", nil, true},
+		{"rate=16", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parsePrecision(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePrecision(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePrecision(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPerfdataEscaping(t *testing.T) {
+	precision, _ := parsePrecision("")
+	tests := []struct {
+		name    string
+		metrics map[string]interface{}
+		want    string
+	}{
+		{"plain version", map[string]interface{}{"version": "30.0.4.1"}, "version=30.0.4.1"},
+		{"suffix", map[string]interface{}{"version": "31.0.0~rc1+git"}, "version=31.0.0~rc1+git"},
+		{"space and pipe", map[string]interface{}{"version": "30.0.4 beta|x"}, "version=30.0.4_beta_x"},
+		{"equals and quote", map[string]interface{}{"version": "30=0'4\"1"}, "version=30_0_4_1"},
+		{"semicolon and newline", map[string]interface{}{"version": "30;0\n4"}, "version=30_0_4"},
+		{"unicode", map[string]interface{}{"version": "30.0.4-bêta"}, "version=30.0.4-b_ta"},
+		{"quoted label", map[string]interface{}{"app count": 3, "o'clock": 1}, "'app count'=3 'o''clock'=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatPerfdata(tt.metrics, nil, nil, precision)
+			if got != tt.want {
+				t.Errorf("formatPerfdata() = %q, want %q", got, tt.want)
+			}
+			if strings.ContainsAny(got, "|\n;") {
+				t.Errorf("perfdata %q contains a separator", got)
+			}
+		})
+	}
+}
+
+func TestPluginText(t *testing.T) {
+	if got, want := pluginText("WARNING - Nextcloud 30.0.4 beta|x running."), "WARNING - Nextcloud 30.0.4 beta/x running."; got != want {
+		t.Errorf("pluginText() = %q, want %q", got, want)
+	}
+}
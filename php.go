@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parsePHPSize parses a size in php.ini shorthand such as "512M" or "2G"
+// into bytes. "-1" means unlimited and is returned as -1.
+func parsePHPSize(spec string) (int64, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "-1" {
+		return -1, nil
+	}
+	if spec == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+
+	multiplier := int64(1)
+	switch strings.ToUpper(spec[len(spec)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	digits := spec
+	if multiplier > 1 {
+		digits = spec[:len(spec)-1]
+	}
+
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("expected bytes or a K, M or G suffix, got %q", spec)
+	}
+	return n * multiplier, nil
+}
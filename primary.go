@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"strings"
+)
+
+// isPrimaryNode reports whether the checked node is the primary of a
+// cluster. It is the primary when either the host of the server URL or the
+// hostname of the machine running the plugin matches primary, ignoring case
+// and the port. The latter covers checks run locally on each node (e.g. via
+// NRPE against localhost).
+func isPrimaryNode(serverURL, primary string) bool {
+	if u, err := url.Parse(serverURL); err == nil && strings.EqualFold(u.Hostname(), primary) {
+		return true
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return false
+	}
+	short, _, _ := strings.Cut(hostname, ".")
+	return strings.EqualFold(hostname, primary) || strings.EqualFold(short, primary)
+}
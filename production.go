@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Production is the --production setting. It implements flag.Value as a
+// boolean flag, so --production alone means true and --production=auto
+// decides per run through appearsProduction.
+type Production string
+
+const (
+	ProductionOff  Production = "false"
+	ProductionOn   Production = "true"
+	ProductionAuto Production = "auto"
+)
+
+func (p *Production) String() string {
+	return string(*p)
+}
+
+func (p *Production) Set(value string) error {
+	if strings.EqualFold(value, string(ProductionAuto)) {
+		*p = ProductionAuto
+		return nil
+	}
+	on, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("expected true, false or auto, got %q", value)
+	}
+	*p = ProductionOff
+	if on {
+		*p = ProductionOn
+	}
+	return nil
+}
+
+func (p *Production) IsBoolFlag() bool {
+	return true
+}
+
+// nonProductionLabels are host name labels that mark test and staging
+// instances, e.g. staging.cloud.example.com or cloud-dev.example.com.
+var nonProductionLabels = []string{"dev", "devel", "test", "testing", "staging", "stage", "qa", "demo", "sandbox", "local"}
+
+// nonProductionSuffixes are domains reserved for or commonly used on
+// internal networks.
+var nonProductionSuffixes = []string{".localhost", ".local", ".test", ".example", ".invalid", ".internal", ".lan", ".home.arpa"}
+
+// appearsProduction guesses whether the instance at serverURL, reached
+// under host when --host-header is set, is a production instance: it is
+// served over https under a public looking name. IP addresses, localhost,
+// internal domains and names with a dev, test or staging label are not.
+func appearsProduction(serverURL, host string) bool {
+	u, err := url.Parse(serverURL)
+	if err != nil || !strings.EqualFold(u.Scheme, "https") {
+		return false
+	}
+	name := strings.ToLower(u.Hostname())
+	if host != "" {
+		name = strings.ToLower(hostWithoutPort(host))
+	}
+	name = strings.TrimSuffix(name, ".")
+
+	if name == "" || name == "localhost" || net.ParseIP(name) != nil || !strings.Contains(name, ".") {
+		return false
+	}
+	for _, suffix := range nonProductionSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return false
+		}
+	}
+	for _, label := range strings.FieldsFunc(name, func(r rune) bool { return r == '.' || r == '-' }) {
+		for _, nonProduction := range nonProductionLabels {
+			if label == nonProduction {
+				return false
+			}
+		}
+	}
+	return true
+}
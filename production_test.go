@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestAppearsProduction(t *testing.T) {
+	tests := []struct {
+		serverURL string
+		host      string
+		want      bool
+	}{
+		{"https://cloud.example.com", "", true},
+		{"https://cloud.example.com:8443/nextcloud", "", true},
+		{"http://cloud.example.com", "", false},
+		{"https://localhost", "", false},
+		{"https://192.0.2.10", "", false},
+		{"https://[2001:db8::1]", "", false},
+		{"https://nextcloud", "", false},
+		{"https://cloud.corp.internal", "", false},
+		{"https://nextcloud.local", "", false},
+		{"https://staging.cloud.example.com", "", false},
+		{"https://cloud-dev.example.com", "", false},
+		{"https://developer.example.com", "", true},
+		{"https://10.0.0.5", "cloud.example.com:443", true},
+		{"https://cloud.example.com", "test.example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.serverURL+" "+tt.host, func(t *testing.T) {
+			if got := appearsProduction(tt.serverURL, tt.host); got != tt.want {
+				t.Errorf("appearsProduction(%q, %q) = %v, want %v", tt.serverURL, tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProductionSet(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Production
+		wantErr bool
+	}{
+		{"true", ProductionOn, false},
+		{"1", ProductionOn, false},
+		{"false", ProductionOff, false},
+		{"AUTO", ProductionAuto, false},
+		{"maybe", ProductionOff, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			p := ProductionOff
+			err := p.Set(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if p != tt.want {
+				t.Errorf("Set(%q) = %q, want %q", tt.value, p, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// fetchGeneratedURL requests /index.php without credentials and returns the
+// absolute login redirect Nextcloud generates for it. Its scheme and host
+// are what the instance detected from the request and the reverse proxy
+// settings (overwriteprotocol, overwritehost, trusted_proxies).
+func fetchGeneratedURL(ctx context.Context, client *http.Client, serverURL string) (*url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", serverURL+"/index.php", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "check_nextcloud/1.0")
+
+	noRedirect := *client
+	noRedirect.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := noRedirect.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 || location == "" {
+		return nil, fmt.Errorf("no redirect (status %d)", resp.StatusCode)
+	}
+	generated, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect %q: %v", location, err)
+	}
+	if !generated.IsAbs() {
+		return nil, fmt.Errorf("relative redirect %q", location)
+	}
+	return generated, nil
+}
+
+// expectedBaseURL is the base URL Nextcloud should generate links for: the
+// --expected-base-url, or else the -s URL with the --host-header applied.
+func expectedBaseURL(cfg Config) (*url.URL, error) {
+	if cfg.ExpectedBaseURL != "" {
+		return url.Parse(cfg.ExpectedBaseURL)
+	}
+	expected, err := url.Parse(cfg.ServerURL)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.HostHeader != "" {
+		expected.Host = cfg.HostHeader
+	}
+	return expected, nil
+}
+
+// sameOrigin compares scheme and host, treating an explicit default port
+// like a missing one.
+func sameOrigin(a, b *url.URL) bool {
+	if !strings.EqualFold(a.Scheme, b.Scheme) {
+		return false
+	}
+	addrA, errA := dialAddress(a.String())
+	addrB, errB := dialAddress(b.String())
+	return errA == nil && errB == nil && strings.EqualFold(addrA, addrB)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// maxRetryBackoff caps a single backoff delay independent of the attempt.
+const maxRetryBackoff = 30 * time.Second
+
+// backoffDelay returns the delay before retry number attempt (starting at 0)
+// using exponential backoff with full jitter: a random duration between 0
+// and base*2^attempt, capped at maxRetryBackoff. The jitter spreads retries
+// of many checks hitting the same recovering instance. random returns a
+// value in [0, 1).
+func backoffDelay(base time.Duration, attempt int, random func() float64) time.Duration {
+	ceiling := base
+	for i := 0; i < attempt && ceiling < maxRetryBackoff; i++ {
+		ceiling *= 2
+	}
+	if ceiling > maxRetryBackoff {
+		ceiling = maxRetryBackoff
+	}
+	return time.Duration(random() * float64(ceiling))
+}
+
+// retryable reports whether a failed serverinfo request may succeed when
+// repeated. Authentication, configuration and response content errors are
+// final.
+func retryable(err error) bool {
+	var connectErr *ConnectError
+	var incompleteErr *IncompleteResponseError
+	return errors.As(err, &connectErr) || errors.As(err, &incompleteErr)
+}
+
+// fetchServerInfoRetry calls fetchServerInfo and retries transient failures
+// up to cfg.Retries times. A retry is skipped when its delay would exceed
+// the deadline of ctx, the last error is returned then.
+func fetchServerInfoRetry(ctx context.Context, client *http.Client, cfg Config) (*OCSResponse, *http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		ocsResp, resp, err := fetchServerInfo(ctx, client, cfg)
+		if err == nil || attempt >= cfg.Retries || !retryable(err) || ctx.Err() != nil {
+			return ocsResp, resp, err
+		}
+
+		delay := backoffDelay(cfg.RetryBackoff, attempt, rand.Float64)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			debugf(cfg, "not retrying, backoff of %s exceeds the remaining timeout", delay)
+			return ocsResp, resp, err
+		}
+		debugf(cfg, "retry %d/%d in %s after: %v", attempt+1, cfg.Retries, delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ocsResp, resp, err
+		}
+	}
+}
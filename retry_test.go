@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    time.Duration
+		attempt int
+		random  float64
+		want    time.Duration
+	}{
+		{"first retry ceiling", time.Second, 0, 0.999999999, 999999999 * time.Nanosecond},
+		{"first retry half", time.Second, 0, 0.5, 500 * time.Millisecond},
+		{"doubles per attempt", time.Second, 1, 0.5, time.Second},
+		{"third retry", time.Second, 3, 0.5, 4 * time.Second},
+		{"zero jitter", time.Second, 4, 0, 0},
+		{"capped", time.Second, 10, 0.5, maxRetryBackoff / 2},
+		{"capped without overflow", time.Second, 80, 0.5, maxRetryBackoff / 2},
+		{"base above cap", time.Minute, 0, 0.5, maxRetryBackoff / 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := backoffDelay(tt.base, tt.attempt, func() float64 { return tt.random })
+			if got != tt.want {
+				t.Errorf("backoffDelay(%v, %d) = %v, want %v", tt.base, tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connect", &ConnectError{Op: "API request failed", Err: errors.New("EOF")}, true},
+		{"incomplete", &IncompleteResponseError{}, true},
+		{"auth", &AuthError{StatusCode: 401}, false},
+		{"resolve", &ResolveError{Host: "nc.invalid"}, false},
+		{"parse", &ParseError{Op: "Invalid API response"}, false},
+		{"app not enabled", &AppNotEnabledError{App: "serverinfo"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchServerInfoRetry(t *testing.T) {
+	fixture := readFixture(t, "serverinfo.json")
+
+	tests := []struct {
+		name      string
+		failures  int32
+		status    int
+		retries   int
+		wantErr   bool
+		wantCalls int32
+	}{
+		{"recovers", 2, 0, 2, false, 3},
+		{"retries exhausted", 3, 0, 2, true, 3},
+		{"no retries", 1, 0, 0, true, 1},
+		{"final error", 5, http.StatusUnauthorized, 3, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&calls, 1) <= tt.failures {
+					if tt.status != 0 {
+						w.WriteHeader(tt.status)
+						return
+					}
+					// Drop the connection without an answer.
+					conn, _, err := w.(http.Hijacker).Hijack()
+					if err == nil {
+						conn.Close()
+					}
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(fixture)
+			}))
+			defer server.Close()
+
+			cfg := testFetchConfig(server.URL)
+			cfg.APIPaths = cfg.APIPaths[:1]
+			cfg.Retries = tt.retries
+			cfg.RetryBackoff = time.Millisecond
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+			defer cancel()
+
+			_, _, err := fetchServerInfoRetry(ctx, server.Client(), cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetchServerInfoRetry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("requests = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
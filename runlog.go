@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// RunRecord is one line of the --log-file audit trail.
+type RunRecord struct {
+	Timestamp  string `json:"timestamp"`
+	Target     string `json:"target"`
+	Status     string `json:"status"`
+	ExitCode   int    `json:"exit_code"`
+	DurationMS int64  `json:"duration_ms"`
+	Message    string `json:"message"`
+}
+
+// statusLine returns the status line of plugin output without perfdata and
+// long output.
+func statusLine(output string) string {
+	output, _, _ = strings.Cut(output, "\n")
+	output, _, _ = strings.Cut(output, " | ")
+	return output
+}
+
+func newRunRecord(target string, state, exitCode int, message string, start time.Time) RunRecord {
+	// Only the status line is logged, perfdata and long output are dropped.
+	message = statusLine(message)
+	return RunRecord{
+		Timestamp:  start.UTC().Format(time.RFC3339),
+		Target:     target,
+		Status:     stateNames[state],
+		ExitCode:   exitCode,
+		DurationMS: time.Since(start).Milliseconds(),
+		Message:    message,
+	}
+}
+
+// writeRunLog appends record as a JSON line to path. Like the perfdata file
+// the log is append only, so it can be rotated externally, and failures are
+// reported on stderr only.
+func writeRunLog(path string, record RunRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode log record: %v\n", err)
+		return
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open log file: %v\n", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write log file: %v\n", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import "fmt"
+
+// sanitizeServerInfo checks the numeric serverinfo values for readings that
+// are impossible, such as negative counts or more free than total memory,
+// which some platforms report. Without strict they are clamped to the
+// nearest sane value and reported with --debug, with strict the first one is
+// returned as InvalidMetricError.
+func sanitizeServerInfo(cfg Config, data *DataInfo) error {
+	var invalid error
+	clamp := func(field string, value *int64, min, max int64) {
+		sane := *value
+		if sane < min {
+			sane = min
+		}
+		if max >= min && sane > max {
+			sane = max
+		}
+		if sane == *value {
+			return
+		}
+		if invalid == nil {
+			invalid = &InvalidMetricError{Field: field, Value: fmt.Sprint(*value)}
+		}
+		debugf(cfg, "clamping invalid %s %d to %d", field, *value, sane)
+		*value = sane
+	}
+	clampInt := func(field string, value *int) {
+		v := int64(*value)
+		clamp(field, &v, 0, -1)
+		*value = int(v)
+	}
+
+	sys := &data.Nextcloud.System
+	clamp("mem_total", &sys.MemTotal, 0, -1)
+	clamp("mem_free", &sys.MemFree, 0, usedBound(sys.MemTotal, 0))
+	clamp("swap_total", &sys.SwapTotal, 0, -1)
+	clamp("swap_free", &sys.SwapFree, 0, usedBound(sys.SwapTotal, 0))
+	// Buffers and cache are added to the free memory by --mem-available, so
+	// together they must fit into the used part.
+	if sys.MemBuffers != nil {
+		clamp("mem_buffers", sys.MemBuffers, 0, usedBound(sys.MemTotal, sys.MemFree))
+	}
+	if sys.MemCached != nil {
+		available := sys.MemFree
+		if sys.MemBuffers != nil {
+			available += *sys.MemBuffers
+		}
+		clamp("mem_cached", sys.MemCached, 0, usedBound(sys.MemTotal, available))
+	}
+	clampInt("num_users", &data.Nextcloud.Storage.NumUsers)
+	clampInt("num_files", &data.Nextcloud.Storage.NumFiles)
+	clampInt("num_shares", &data.Nextcloud.Shares.NumShares)
+
+	for i, load := range sys.Cpuload {
+		if load < 0 {
+			if invalid == nil {
+				invalid = &InvalidMetricError{Field: "cpuload", Value: fmt.Sprint(load)}
+			}
+			debugf(cfg, "clamping invalid cpuload %v to 0", load)
+			sys.Cpuload[i] = 0
+		}
+	}
+
+	hitRate := &data.Server.PHP.Opcache.OpcacheStatistics.OpcacheHitRate
+	if *hitRate < 0 || *hitRate > 100 {
+		if invalid == nil {
+			invalid = &InvalidMetricError{Field: "opcache_hit_rate", Value: fmt.Sprint(*hitRate)}
+		}
+		debugf(cfg, "clamping invalid opcache_hit_rate %v", *hitRate)
+		*hitRate = min(max(*hitRate, 0), 100)
+	}
+
+	if cfg.Strict {
+		return invalid
+	}
+	return nil
+}
+
+// usedBound returns the part of total not taken by free as the upper bound
+// of a clamp. A total of 0 means the platform does not report it, the bound
+// is -1 (none) then.
+func usedBound(total, free int64) int64 {
+	if total == 0 {
+		return -1
+	}
+	return total - free
+}
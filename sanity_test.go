@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeServerInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		// system is the nextcloud.system object of the fixture.
+		system    string
+		opcache   string
+		wantField string
+		check     func(t *testing.T, sys *NextcloudSystem, hitRate float64)
+	}{
+		{
+			name:   "valid",
+			system: `{"mem_total": 1000, "mem_free": 400, "mem_buffers": 100, "mem_cached": 200, "swap_total": 0, "swap_free": 0, "freespace": 5}`,
+		},
+		{
+			name:      "negative free memory",
+			system:    `{"mem_total": 1000, "mem_free": -5}`,
+			wantField: "mem_free",
+			check: func(t *testing.T, sys *NextcloudSystem, _ float64) {
+				if sys.MemFree != 0 {
+					t.Errorf("mem_free = %d, want 0", sys.MemFree)
+				}
+			},
+		},
+		{
+			name:      "free above total",
+			system:    `{"mem_total": 1000, "mem_free": 1500}`,
+			wantField: "mem_free",
+			check: func(t *testing.T, sys *NextcloudSystem, _ float64) {
+				if sys.MemFree != 1000 {
+					t.Errorf("mem_free = %d, want 1000", sys.MemFree)
+				}
+			},
+		},
+		{
+			name:   "total not reported",
+			system: `{"mem_total": 0, "mem_free": 1500, "swap_total": 0, "swap_free": 20}`,
+			check: func(t *testing.T, sys *NextcloudSystem, _ float64) {
+				if sys.MemFree != 1500 || sys.SwapFree != 20 {
+					t.Errorf("mem_free = %d, swap_free = %d, want 1500 and 20 unchanged", sys.MemFree, sys.SwapFree)
+				}
+			},
+		},
+		{
+			name:      "negative buffers",
+			system:    `{"mem_total": 1000, "mem_free": 400, "mem_buffers": -100, "mem_cached": 200}`,
+			wantField: "mem_buffers",
+			check: func(t *testing.T, sys *NextcloudSystem, _ float64) {
+				if *sys.MemBuffers != 0 {
+					t.Errorf("mem_buffers = %d, want 0", *sys.MemBuffers)
+				}
+			},
+		},
+		{
+			name:      "cache above used memory",
+			system:    `{"mem_total": 1000, "mem_free": 400, "mem_buffers": 100, "mem_cached": 900}`,
+			wantField: "mem_cached",
+			check: func(t *testing.T, sys *NextcloudSystem, _ float64) {
+				if *sys.MemCached != 500 {
+					t.Errorf("mem_cached = %d, want 500", *sys.MemCached)
+				}
+			},
+		},
+		{
+			name:      "negative swap total",
+			system:    `{"mem_total": 1000, "mem_free": 400, "swap_total": -1, "swap_free": 0}`,
+			wantField: "swap_total",
+		},
+		{
+			name:      "negative load",
+			system:    `{"mem_total": 1000, "mem_free": 400, "cpuload": [0.5, -1, 0.2]}`,
+			wantField: "cpuload",
+			check: func(t *testing.T, sys *NextcloudSystem, _ float64) {
+				if sys.Cpuload[1] != 0 {
+					t.Errorf("cpuload = %v, want the negative value clamped to 0", sys.Cpuload)
+				}
+			},
+		},
+		{
+			name:      "hit rate above 100",
+			system:    `{"mem_total": 1000, "mem_free": 400}`,
+			opcache:   `{"opcache_hit_rate": 180}`,
+			wantField: "opcache_hit_rate",
+			check: func(t *testing.T, _ *NextcloudSystem, hitRate float64) {
+				if hitRate != 100 {
+					t.Errorf("opcache_hit_rate = %v, want 100", hitRate)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, strict := range []bool{false, true} {
+			name := tt.name
+			if strict {
+				name += " strict"
+			}
+			t.Run(name, func(t *testing.T) {
+				opcache := tt.opcache
+				if opcache == "" {
+					opcache = `{"opcache_hit_rate": 90}`
+				}
+				fixture := `{"nextcloud": {"system": ` + tt.system + `}, "server": {"php": {"opcache": {"opcache_statistics": ` + opcache + `}}}}`
+				var data DataInfo
+				if err := json.Unmarshal([]byte(fixture), &data); err != nil {
+					t.Fatal(err)
+				}
+
+				err := sanitizeServerInfo(Config{Strict: strict}, &data)
+				if !strict || tt.wantField == "" {
+					if err != nil {
+						t.Fatalf("sanitizeServerInfo() = %v, want nil", err)
+					}
+				} else {
+					var invalidErr *InvalidMetricError
+					if !errors.As(err, &invalidErr) {
+						t.Fatalf("sanitizeServerInfo() = %v, want InvalidMetricError", err)
+					}
+					if invalidErr.Field != tt.wantField {
+						t.Errorf("Field = %q, want %q", invalidErr.Field, tt.wantField)
+					}
+				}
+				if tt.check != nil {
+					tt.check(t, &data.Nextcloud.System, data.Server.PHP.Opcache.OpcacheStatistics.OpcacheHitRate)
+				}
+			})
+		}
+	}
+}
+
+// TestMemAvailableNotNegative checks that buffers and cache above the used
+// memory do not turn the --mem-available usage negative.
+func TestMemAvailableNotNegative(t *testing.T) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(readFixture(t, "serverinfo.json"), &doc); err != nil {
+		t.Fatal(err)
+	}
+	system := doc["ocs"].(map[string]interface{})["data"].(map[string]interface{})["nextcloud"].(map[string]interface{})["system"].(map[string]interface{})
+	system["mem_buffers"] = 8000000
+	system["mem_cached"] = 9000000
+	fixture, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, _, err := runFixtureCheck(t, fixture, func(cfg *Config) { cfg.MemAvailable = true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perfdata := splitPerfdata(t, output) + " "; !strings.Contains(perfdata, "memory_usage_percent=0;") {
+		t.Errorf("perfdata lacks memory_usage_percent=0: %s", perfdata)
+	}
+}
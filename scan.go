@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const securityScanURL = "https://scan.nextcloud.com"
+
+// securityGrades lists the grades of the Nextcloud security scanner, indexed
+// by the numeric rating returned by its API (worst first).
+var securityGrades = []string{"F", "E", "D", "C", "A", "A+"}
+
+func securityGradeRank(grade string) int {
+	for i, g := range securityGrades {
+		if strings.EqualFold(g, grade) {
+			return i
+		}
+	}
+	return -1
+}
+
+// SecurityScan is the scanner state kept in --state-file, so a run only
+// contacts the scanner when the cached grade is outdated.
+type SecurityScan struct {
+	// UUID identifies the instance at the scanner, it is assigned when the
+	// first scan is queued.
+	UUID  string `json:"uuid"`
+	Grade string `json:"grade,omitempty"`
+	// ScannedAt is the Unix time of the scan Grade comes from.
+	ScannedAt int64 `json:"scanned_at,omitempty"`
+	// QueuedAt is the Unix time the last scan was queued.
+	QueuedAt int64 `json:"queued_at,omitempty"`
+}
+
+// scanResultTime is the layout of the scannedAt date in scanner results.
+const scanResultTime = "2006-01-02 15:04:05.000000"
+
+// fetchSecurityGrade returns the scanner state for serverURL starting from
+// the cached one. A grade younger than maxAge is reused without contacting
+// the scanner. Otherwise the existing result is read, and a new scan is only
+// queued when there is none or it is older than maxAge and no scan was
+// queued within maxAge. The returned Grade is empty until the first scan
+// finished.
+func fetchSecurityGrade(ctx context.Context, client *http.Client, serverURL string, cached *SecurityScan, maxAge time.Duration, now time.Time) (*SecurityScan, error) {
+	scan := &SecurityScan{}
+	if cached != nil {
+		*scan = *cached
+	}
+	stale := func() bool {
+		return scan.Grade == "" || now.Sub(time.Unix(scan.ScannedAt, 0)) >= maxAge
+	}
+	if !stale() {
+		return scan, nil
+	}
+
+	if scan.UUID != "" {
+		var result struct {
+			Rating    *int `json:"rating"`
+			ScannedAt struct {
+				Date     string `json:"date"`
+				Timezone string `json:"timezone"`
+			} `json:"scannedAt"`
+		}
+		if err := scanRequest(ctx, client, "GET", securityScanURL+"/api/result/"+url.PathEscape(scan.UUID), nil, &result); err != nil {
+			return nil, err
+		}
+		if result.Rating != nil && *result.Rating >= 0 && *result.Rating < len(securityGrades) {
+			scan.Grade = securityGrades[*result.Rating]
+			scan.ScannedAt = now.Unix()
+			if loc, err := time.LoadLocation(result.ScannedAt.Timezone); err == nil {
+				if scannedAt, err := time.ParseInLocation(scanResultTime, result.ScannedAt.Date, loc); err == nil {
+					scan.ScannedAt = scannedAt.Unix()
+				}
+			}
+		}
+	}
+
+	if stale() && now.Sub(time.Unix(scan.QueuedAt, 0)) >= maxAge {
+		var queued struct {
+			UUID string `json:"uuid"`
+		}
+		form := url.Values{"url": {serverHost(serverURL)}}
+		if err := scanRequest(ctx, client, "POST", securityScanURL+"/api/queue", form, &queued); err != nil {
+			return nil, err
+		}
+		if queued.UUID == "" {
+			return nil, fmt.Errorf("no scan id returned")
+		}
+		scan.UUID = queued.UUID
+		scan.QueuedAt = now.Unix()
+	}
+
+	return scan, nil
+}
+
+func scanRequest(ctx context.Context, client *http.Client, method, scanURL string, form url.Values, v interface{}) error {
+	var body *strings.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	} else {
+		body = strings.NewReader("")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, scanURL, body)
+	if err != nil {
+		return err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "check_nextcloud/1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a daily window in minutes since midnight. A window with
+// Start after End wraps around midnight.
+type TimeWindow struct {
+	Start int
+	End   int
+}
+
+// parseTimeWindow parses a "HH:MM-HH:MM" window such as "08:00-18:00".
+func parseTimeWindow(spec string) (*TimeWindow, error) {
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, fmt.Errorf("expected HH:MM-HH:MM, got %q", spec)
+	}
+
+	start, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return nil, fmt.Errorf("invalid start time %q", from)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return nil, fmt.Errorf("invalid end time %q", to)
+	}
+
+	window := &TimeWindow{Start: start.Hour()*60 + start.Minute(), End: end.Hour()*60 + end.Minute()}
+	if window.Start == window.End {
+		return nil, fmt.Errorf("empty window %q", spec)
+	}
+	return window, nil
+}
+
+// contains reports whether the wall clock time of t lies within the window.
+// The start is inclusive, the end exclusive.
+func (w *TimeWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.Start < w.End {
+		return minute >= w.Start && minute < w.End
+	}
+	return minute >= w.Start || minute < w.End
+}
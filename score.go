@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Health score states: below scoreCritical is CRITICAL, below scoreWarning
+// is WARNING.
+const (
+	scoreWarning  = 75
+	scoreCritical = 50
+)
+
+// scoreComponents lists the inputs of the health score in output order.
+var scoreComponents = []string{"memory", "swap", "cpu", "disk", "opcache"}
+
+// parseScoreWeights parses a --score-weights spec like "memory=2,cpu=1".
+// Components not mentioned keep a weight of 1.
+func parseScoreWeights(spec string) (map[string]float64, error) {
+	weights := map[string]float64{}
+	for _, component := range scoreComponents {
+		weights[component] = 1
+	}
+	if spec == "" {
+		return weights, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected component=weight, got %q", pair)
+		}
+		name = strings.TrimSpace(name)
+		if _, known := weights[name]; !known {
+			return nil, fmt.Errorf("unknown component %q", name)
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q", value)
+		}
+		weights[name] = weight
+	}
+
+	return weights, nil
+}
+
+// healthScore computes a 0-100 score from the utilization (0-100, higher is
+// worse) of each available component:
+//
+//	score = 100 - sum(weight * utilization) / sum(weight)
+//
+// Components missing from utilization (e.g. disk when serverinfo does not
+// report the total size) are left out of both sums. With no usable component
+// the score is 100.
+func healthScore(utilization map[string]float64, weights map[string]float64) float64 {
+	weighted, total := 0.0, 0.0
+	for _, component := range scoreComponents {
+		value, ok := utilization[component]
+		if !ok {
+			continue
+		}
+		value = math.Max(0, math.Min(100, value))
+		weighted += weights[component] * value
+		total += weights[component]
+	}
+	if total == 0 {
+		return 100
+	}
+	return 100 - weighted/total
+}
+
+func scoreState(score float64) int {
+	switch {
+	case score < scoreCritical:
+		return StateCritical
+	case score < scoreWarning:
+		return StateWarning
+	default:
+		return StateOK
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type OCSResponse struct {
+	OCS struct {
+		Meta MetaInfo `json:"meta"`
+		Data DataInfo `json:"data"`
+	} `json:"ocs"`
+	// BodyBytes is the size of the response body as read, after any
+	// transparent decompression.
+	BodyBytes int `json:"-"`
+}
+
+type MetaInfo struct {
+	Status     string `json:"status"`
+	StatusCode int    `json:"statuscode"`
+	Message    string `json:"message"`
+}
+
+type DataInfo struct {
+	Nextcloud   NextcloudInfo   `json:"nextcloud"`
+	Server      ServerInfo      `json:"server"`
+	ActiveUsers ActiveUsersInfo `json:"activeUsers"`
+}
+
+type NextcloudInfo struct {
+	System  NextcloudSystem  `json:"system"`
+	Storage NextcloudStorage `json:"storage"`
+	Shares  NextcloudShares  `json:"shares"`
+}
+
+type NextcloudSystem struct {
+	Version   string        `json:"version"`
+	Edition   string        `json:"edition"`
+	Cpuload   []float64     `json:"cpuload"`
+	MemTotal  int64         `json:"mem_total"`
+	MemFree   int64         `json:"mem_free"`
+	SwapTotal int64         `json:"swap_total"`
+	SwapFree  int64         `json:"swap_free"`
+	Apps      NextcloudApps `json:"apps"`
+	Update    UpdateInfo    `json:"update"`
+	Debug     bool          `json:"debug"`
+	CPUNum    int           `json:"cpunum"`
+	FreeSpace int64         `json:"freespace"`
+
+	// The following fields are only reported by some serverinfo releases
+	// and stay nil otherwise.
+	MemBuffers     *int64   `json:"mem_buffers"`
+	MemCached      *int64   `json:"mem_cached"`
+	TrustedDomains []string `json:"trusted_domains"`
+	LogLevel       *int     `json:"loglevel"`
+	LogFileSize    *int64   `json:"logfile_size"`
+	DiskTotal      *int64   `json:"disk_total"`
+	Timezone       *string  `json:"timezone"`
+	DefaultLocale  *string  `json:"default_locale"`
+	// CPUSteal and CPUIowait are percentages of CPU time.
+	CPUSteal  *float64 `json:"cpu_steal"`
+	CPUIowait *float64 `json:"cpu_iowait"`
+}
+
+type NextcloudApps struct {
+	NumInstalled        int `json:"num_installed"`
+	NumUpdatesAvailable int `json:"num_updates_available"`
+}
+
+type NextcloudStorage struct {
+	NumUsers    int `json:"num_users"`
+	NumFiles    int `json:"num_files"`
+	NumStorages int `json:"num_storages"`
+	// ReadOnly is only reported by serverinfo releases that expose the
+	// writability of the data directory. It stays nil otherwise and the
+	// read-only check is skipped.
+	ReadOnly *bool `json:"readonly"`
+	// PrimaryStorage names the primary storage backend (e.g. local or s3)
+	// on serverinfo releases that report it.
+	PrimaryStorage *string `json:"primary_storage"`
+	// ExternalStorages lists the free space of external mounts on
+	// serverinfo releases that report it and stays nil otherwise.
+	ExternalStorages []ExternalStorage `json:"external_storages"`
+}
+
+type ExternalStorage struct {
+	MountPoint string `json:"mount_point"`
+	Free       int64  `json:"free"`
+	Total      int64  `json:"total"`
+}
+
+type NextcloudShares struct {
+	NumShares int `json:"num_shares"`
+}
+
+type ServerInfo struct {
+	Webserver string       `json:"webserver"`
+	PHP       PHPInfo      `json:"php"`
+	Database  DatabaseInfo `json:"database"`
+}
+
+type PHPInfo struct {
+	Version string         `json:"version"`
+	Opcache PHPOpcacheInfo `json:"opcache"`
+	// MemoryLimit is memory_limit in bytes, -1 when unlimited. It stays nil
+	// when serverinfo does not report it.
+	MemoryLimit *int64 `json:"memory_limit"`
+}
+
+type PHPOpcacheInfo struct {
+	// OpcacheEnabled stays nil when serverinfo does not report the flag.
+	OpcacheEnabled    *bool                 `json:"opcache_enabled"`
+	OpcacheStatistics OpcacheStatisticsInfo `json:"opcache_statistics"`
+	// The memory blocks stay nil when serverinfo does not report them.
+	MemoryUsage          *OpcacheMemoryUsage          `json:"memory_usage"`
+	InternedStringsUsage *OpcacheInternedStringsUsage `json:"interned_strings_usage"`
+}
+
+type OpcacheMemoryUsage struct {
+	UsedMemory   int64 `json:"used_memory"`
+	FreeMemory   int64 `json:"free_memory"`
+	WastedMemory int64 `json:"wasted_memory"`
+}
+
+type OpcacheInternedStringsUsage struct {
+	BufferSize int64 `json:"buffer_size"`
+	UsedMemory int64 `json:"used_memory"`
+}
+
+type OpcacheStatisticsInfo struct {
+	OpcacheHitRate float64 `json:"opcache_hit_rate"`
+	// The restart counters stay nil when serverinfo does not report them.
+	OOMRestarts    *int `json:"oom_restarts"`
+	HashRestarts   *int `json:"hash_restarts"`
+	ManualRestarts *int `json:"manual_restarts"`
+	// The script cache fill level stays nil when not reported.
+	NumCachedScripts *int `json:"num_cached_scripts"`
+	NumCachedKeys    *int `json:"num_cached_keys"`
+	MaxCachedKeys    *int `json:"max_cached_keys"`
+}
+
+type DatabaseInfo struct {
+	Type    string `json:"type"`
+	Version string `json:"version"`
+	// Queries and SlowQueries are only reported by some serverinfo
+	// releases and stay nil otherwise.
+	Queries     *int64 `json:"queries"`
+	SlowQueries *int64 `json:"slow_queries"`
+	// The schema repairs pending after an upgrade (occ db:add-missing-*)
+	// stay nil unless serverinfo reports them.
+	MissingIndices     []string `json:"missing_indices"`
+	MissingColumns     []string `json:"missing_columns"`
+	MissingPrimaryKeys []string `json:"missing_primary_keys"`
+}
+
+type ActiveUsersInfo struct {
+	Last5minutes int `json:"last5minutes"`
+	Last1hour    int `json:"last1hour"`
+	Last24hours  int `json:"last24hours"`
+	Last7days    int `json:"last7days"`
+	Last1month   int `json:"last1month"`
+	Last3months  int `json:"last3months"`
+	Last6months  int `json:"last6months"`
+	Lastyear     int `json:"lastyear"`
+}
+
+type UpdateInfo struct {
+	LastUpdatedAt    int64  `json:"lastupdatedat"`
+	Available        bool   `json:"available"`
+	AvailableVersion string `json:"available_version"`
+}
+
+// responseFormats maps the supported values of --format to the Accept header
+// sent with the request. Only formats the response parser understands may be
+// added here.
+var responseFormats = map[string]string{
+	"json": "application/json",
+}
+
+// defaultServerinfoVersion is the API version segment of the serverinfo
+// endpoint, see --serverinfo-version.
+const defaultServerinfoVersion = "v1"
+
+// nextcloudVersionPattern matches the system.version of Nextcloud releases,
+// e.g. 30.0.4.1.
+var nextcloudVersionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+(\.\d+)?$`)
+
+var serverinfoVersionPattern = regexp.MustCompile(`^v[0-9]+$`)
+
+// defaultAPIPaths returns the serverinfo endpoints tried in order when
+// --api-path is not given, for the given API version segment.
+func defaultAPIPaths(version string) []string {
+	return []string{
+		"/ocs/v2.php/apps/serverinfo/api/" + version + "/info",
+		"/ocs/v1.php/apps/serverinfo/api/" + version + "/info",
+	}
+}
+
+// xmlFallbackQuery is the query of the single retry made when a path answers
+// with XML, see fetchServerInfo.
+const xmlFallbackQuery = "format=json"
+
+// fetchServerInfo tries every path in cfg.APIPaths and returns the first
+// valid OCS response. Authentication, connection, resolution and cancellation
+// errors end the search early since another path cannot fix them. When all paths fail
+// the errors of every attempt are combined.
+func fetchServerInfo(ctx context.Context, client *http.Client, cfg Config) (*OCSResponse, *http.Response, error) {
+	var attempts []error
+	for _, path := range cfg.APIPaths {
+		ocsResp, resp, err := fetchServerInfoPath(ctx, client, cfg, path, false)
+
+		// Proxies that rewrite or strip the query string make OCS fall back
+		// to XML. Retry once with only the format parameter and keep the
+		// original error if that does not help either.
+		var xmlErr *XMLResponseError
+		if errors.As(err, &xmlErr) && cfg.Format == "json" {
+			debugf(cfg, "serverinfo endpoint %s returned XML, retrying with ?%s", path, xmlFallbackQuery)
+			if fallbackResp, fallbackHTTPResp, fallbackErr := fetchServerInfoPath(ctx, client, cfg, path, true); fallbackErr == nil {
+				ocsResp, resp, err = fallbackResp, fallbackHTTPResp, nil
+			} else {
+				debugf(cfg, "serverinfo endpoint %s fallback failed: %v", path, fallbackErr)
+			}
+		}
+
+		if err == nil {
+			debugf(cfg, "serverinfo endpoint %s succeeded", path)
+			return ocsResp, resp, nil
+		}
+		debugf(cfg, "serverinfo endpoint %s failed: %v", path, err)
+
+		var authErr *AuthError
+		var connectErr *ConnectError
+		var resolveErr *ResolveError
+		var maintenanceErr *MaintenanceError
+		if errors.As(err, &authErr) || errors.As(err, &connectErr) || errors.As(err, &resolveErr) || errors.As(err, &maintenanceErr) || ctx.Err() != nil {
+			return nil, nil, err
+		}
+		attempts = append(attempts, &AttemptError{Path: path, Err: err})
+	}
+
+	if len(attempts) == 1 {
+		return nil, nil, errors.Unwrap(attempts[0])
+	}
+	return nil, nil, &AttemptsError{Errs: attempts}
+}
+
+// maintenanceHeader reports whether the X-Nextcloud-Maintenance-Mode
+// header marks the response as served in maintenance mode.
+func maintenanceHeader(header http.Header) bool {
+	value := strings.TrimSpace(header.Get("X-Nextcloud-Maintenance-Mode"))
+	return value != "" && value != "0" && !strings.EqualFold(value, "false")
+}
+
+// fetchServerInfoPath queries a single serverinfo endpoint of cfg.ServerURL
+// and decodes its OCS response. The HTTP response is returned as well for
+// checks that evaluate headers or connection details; its body is already
+// consumed. With xmlFallback only xmlFallbackQuery is sent.
+func fetchServerInfoPath(ctx context.Context, client *http.Client, cfg Config, path string, xmlFallback bool) (*OCSResponse, *http.Response, error) {
+	apiURL := fmt.Sprintf("%s%s?format=%s&skipApps=false&skipUpdate=false", cfg.ServerURL, path, url.QueryEscape(cfg.Format))
+	if xmlFallback {
+		apiURL = cfg.ServerURL + path + "?" + xmlFallbackQuery
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return nil, nil, &ConnectError{Op: "Failed to create request", Err: err}
+	}
+	req.Header.Set("NC-Token", cfg.Token)
+	req.Header.Set("Accept", responseFormats[cfg.Format])
+	req.Header.Set("User-Agent", "check_nextcloud/1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return nil, nil, &ResolveError{Host: dnsErr.Name, Err: err}
+		}
+		return nil, nil, &ConnectError{Op: "API request failed", Err: err}
+	}
+	defer resp.Body.Close()
+
+	// In maintenance mode Nextcloud answers with a 503 HTML page, which
+	// would otherwise be reported as a content error.
+	if maintenanceHeader(resp.Header) {
+		return nil, nil, &MaintenanceError{State: cfg.MaintenanceState}
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, nil, &AuthError{StatusCode: resp.StatusCode}
+	}
+
+	if resp.StatusCode == http.StatusNotFound && baseReachable(ctx, client, cfg.ServerURL) {
+		return nil, nil, &AppNotEnabledError{App: "serverinfo"}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, nil, &IncompleteResponseError{Err: err}
+	}
+	if err != nil {
+		return nil, nil, &ConnectError{Op: "Failed to read API response", Err: err}
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	contentType := resp.Header.Get("Content-Type")
+	if bytes.HasPrefix(trimmed, []byte("<?xml")) || strings.HasPrefix(contentType, "application/xml") || strings.HasPrefix(contentType, "text/xml") {
+		return nil, nil, &XMLResponseError{}
+	}
+	if strings.HasPrefix(contentType, "text/html") || bytes.HasPrefix(trimmed, []byte("<")) {
+		return nil, nil, &ContentError{Message: "received HTML instead of JSON - check URL/auth"}
+	}
+
+	// OCS failures carry an empty data array, so the meta block is checked
+	// before decoding the full response.
+	var envelope struct {
+		OCS struct {
+			Meta MetaInfo `json:"meta"`
+		} `json:"ocs"`
+	}
+	if json.Unmarshal(body, &envelope) == nil {
+		if code := envelope.OCS.Meta.StatusCode; code != 0 && code != 100 && code != 200 {
+			return nil, nil, &OCSError{StatusCode: code, Message: envelope.OCS.Meta.Message}
+		}
+	}
+
+	var ocsResp OCSResponse
+	err = json.Unmarshal(body, &ocsResp)
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) && syntaxErr.Offset >= int64(len(body)) {
+		return nil, nil, &IncompleteResponseError{Err: err}
+	}
+	if err != nil {
+		return nil, nil, &ParseError{Op: "Failed to parse API response", Err: err}
+	}
+
+	if ocsResp.OCS.Data.Nextcloud.System.Version == "" {
+		return nil, nil, &ParseError{Op: "Invalid API response"}
+	}
+	// Another product's JSON may happen to have a version field as well.
+	if version := ocsResp.OCS.Data.Nextcloud.System.Version; !nextcloudVersionPattern.MatchString(version) {
+		return nil, nil, &ContentError{Message: fmt.Sprintf("unexpected version format %q - wrong endpoint?", version)}
+	}
+
+	ocsResp.BodyBytes = len(body)
+	return &ocsResp, resp, nil
+}
+
+// baseReachable reports whether the instance itself answers, which tells a
+// disabled serverinfo app apart from a wrong server URL.
+func baseReachable(ctx context.Context, client *http.Client, serverURL string) bool {
+	req, err := http.NewRequestWithContext(ctx, "GET", serverURL+"/status.php", nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("User-Agent", "check_nextcloud/1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK
+}
+
+// StatusInfo is the response of the unauthenticated status.php endpoint.
+type StatusInfo struct {
+	Installed       bool   `json:"installed"`
+	Maintenance     bool   `json:"maintenance"`
+	NeedsDbUpgrade  bool   `json:"needsDbUpgrade"`
+	Version         string `json:"version"`
+	VersionString   string `json:"versionstring"`
+	Edition         string `json:"edition"`
+	ProductName     string `json:"productname"`
+	ExtendedSupport bool   `json:"extendedSupport"`
+}
+
+// fetchStatus queries status.php of serverURL.
+func fetchStatus(ctx context.Context, client *http.Client, serverURL string) (*StatusInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", serverURL+"/status.php", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "check_nextcloud/1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var status StatusInfo
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("invalid status.php response: %v", err)
+	}
+	return &status, nil
+}
+
+// statusCondition maps a status.php response to the condition shown in the
+// status line and its state.
+func statusCondition(instanceStatus *StatusInfo) (string, int) {
+	switch {
+	case !instanceStatus.Installed:
+		return "Not Installed", StateCritical
+	case instanceStatus.NeedsDbUpgrade:
+		return "Needs Upgrade", StateCritical
+	case instanceStatus.Maintenance:
+		return "In Maintenance Mode", StateWarning
+	default:
+		return "Up", StateOK
+	}
+}
+
+// statusName names the instance with the version reported by status.php.
+func statusName(instanceStatus *StatusInfo) string {
+	switch {
+	case instanceStatus.VersionString != "":
+		return "Nextcloud " + instanceStatus.VersionString
+	case instanceStatus.Version != "":
+		return "Nextcloud " + instanceStatus.Version
+	default:
+		return "Nextcloud"
+	}
+}
+
+// checkStatus implements --mode status, a liveness check based on
+// status.php only. It needs no NC-Token.
+func checkStatus(ctx context.Context, client *http.Client, cfg Config) (string, int, error) {
+	instanceStatus, err := fetchStatus(ctx, client, cfg.ServerURL)
+	if err != nil {
+		return "", 0, &ConnectError{Op: "status.php request failed", Err: err}
+	}
+	condition, state := statusCondition(instanceStatus)
+	return stateNames[state] + " - " + statusName(instanceStatus) + " " + condition, state, nil
+}
+
+// statusFallback reports the instance state from status.php after the
+// serverinfo request failed with fetchErr. A healthy instance is reported as
+// WARNING since serverinfo is still unavailable. It returns false when
+// status.php is unavailable as well, so the original error stands.
+func statusFallback(ctx context.Context, client *http.Client, cfg Config, fetchErr error) (string, int, bool) {
+	instanceStatus, err := fetchStatus(ctx, client, cfg.ServerURL)
+	if err != nil {
+		debugf(cfg, "status.php fallback failed: %v", err)
+		return "", 0, false
+	}
+
+	condition, state := statusCondition(instanceStatus)
+	if state == StateOK {
+		condition, state = "Up, Serverinfo Unavailable", StateWarning
+	}
+	return fmt.Sprintf("%s - %s %s (status.php, serverinfo failed: %v)", stateNames[state], statusName(instanceStatus), condition, fetchErr), state, true
+}
+
+// probeInstance sends a lightweight HEAD request to the base URL to tell a
+// completely unreachable instance apart from a broken serverinfo endpoint.
+func probeInstance(ctx context.Context, client *http.Client, serverURL string) error {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", serverURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "check_nextcloud/1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
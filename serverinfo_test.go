@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFetchConfig returns the configuration fetchServerInfo needs to query
+// serverURL.
+func testFetchConfig(serverURL string) Config {
+	return Config{
+		ServerURL:        serverURL,
+		Token:            "secret",
+		Format:           "json",
+		APIPaths:         defaultAPIPaths("v1"),
+		Timeout:          5 * time.Second,
+		MaintenanceState: StateWarning,
+	}
+}
+
+func TestFetchServerInfoNotFound(t *testing.T) {
+	tests := []struct {
+		name          string
+		statusPHP     int
+		wantAppErr    bool
+		wantExitState int
+	}{
+		{"instance reachable", http.StatusOK, true, StateUnknown},
+		{"instance not found", http.StatusNotFound, false, StateCritical},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/status.php" {
+					w.WriteHeader(tt.statusPHP)
+					w.Write([]byte(`{"installed":true}`))
+					return
+				}
+				http.NotFound(w, r)
+			}))
+			defer server.Close()
+
+			cfg := testFetchConfig(server.URL)
+			_, _, err := fetchServerInfo(context.Background(), server.Client(), cfg)
+			if err == nil {
+				t.Fatal("fetchServerInfo succeeded on a 404")
+			}
+
+			var appErr *AppNotEnabledError
+			if got := errors.As(err, &appErr); got != tt.wantAppErr {
+				t.Fatalf("AppNotEnabledError = %v, want %v (err: %v)", got, tt.wantAppErr, err)
+			}
+			if tt.wantAppErr && appErr.App != "serverinfo" {
+				t.Errorf("App = %q, want serverinfo", appErr.App)
+			}
+			if got := exitCodeForError(err); got != tt.wantExitState {
+				t.Errorf("exit state = %d, want %d", got, tt.wantExitState)
+			}
+		})
+	}
+}
+
+func TestFetchServerInfoUnresolvableHost(t *testing.T) {
+	cfg := testFetchConfig("https://nextcloud.does-not-exist.invalid")
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	_, _, err := fetchServerInfo(ctx, http.DefaultClient, cfg)
+
+	var resolveErr *ResolveError
+	if !errors.As(err, &resolveErr) {
+		t.Fatalf("err = %v, want ResolveError", err)
+	}
+	if resolveErr.Host != "nextcloud.does-not-exist.invalid" {
+		t.Errorf("Host = %q, want nextcloud.does-not-exist.invalid", resolveErr.Host)
+	}
+	if got := exitCodeForError(err); got != StateUnknown {
+		t.Errorf("exit state = %d, want %d", got, StateUnknown)
+	}
+}
+
+func TestFetchServerInfoTruncated(t *testing.T) {
+	fixture := readFixture(t, "serverinfo.json")
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{
+			// The body ends early while the connection stays intact.
+			name: "truncated json",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(fixture[:len(fixture)/2])
+			},
+		},
+		{
+			// The connection drops before Content-Length bytes were sent.
+			name: "connection dropped",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Content-Length", strconv.Itoa(len(fixture)))
+				w.Write(fixture[:len(fixture)/2])
+				w.(http.Flusher).Flush()
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					conn.Close()
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			cfg := testFetchConfig(server.URL)
+			cfg.APIPaths = cfg.APIPaths[:1]
+			_, _, err := fetchServerInfo(context.Background(), server.Client(), cfg)
+
+			var incompleteErr *IncompleteResponseError
+			if !errors.As(err, &incompleteErr) {
+				t.Fatalf("err = %v, want IncompleteResponseError", err)
+			}
+			if got := exitCodeForError(err); got != StateUnknown {
+				t.Errorf("exit state = %d, want %d", got, StateUnknown)
+			}
+		})
+	}
+}
+
+func TestFetchServerInfoXML(t *testing.T) {
+	fixture := readFixture(t, "serverinfo.json")
+	const xmlBody = `<?xml version="1.0"?>
+<ocs><meta><status>ok</status><statuscode>200</statuscode></meta><data><nextcloud><system><version>30.0.4.1</version></system></nextcloud></data></ocs>`
+
+	tests := []struct {
+		name string
+		// fallbackJSON answers the format=json only retry with JSON.
+		fallbackJSON bool
+		contentType  string
+		wantXMLErr   bool
+	}{
+		{"xml declaration", false, "text/plain", true},
+		{"xml content type", false, "application/xml; charset=utf-8", true},
+		{"fallback succeeds", true, "application/xml", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.fallbackJSON && r.URL.RawQuery == xmlFallbackQuery {
+					w.Header().Set("Content-Type", "application/json")
+					w.Write(fixture)
+					return
+				}
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte(xmlBody))
+			}))
+			defer server.Close()
+
+			cfg := testFetchConfig(server.URL)
+			cfg.APIPaths = cfg.APIPaths[:1]
+			ocsResp, _, err := fetchServerInfo(context.Background(), server.Client(), cfg)
+
+			if !tt.wantXMLErr {
+				if err != nil {
+					t.Fatalf("fetchServerInfo() = %v, want the fallback response", err)
+				}
+				if version := ocsResp.OCS.Data.Nextcloud.System.Version; version != "30.0.4.1" {
+					t.Errorf("version = %q, want 30.0.4.1", version)
+				}
+				return
+			}
+			var xmlErr *XMLResponseError
+			if !errors.As(err, &xmlErr) {
+				t.Fatalf("err = %v, want XMLResponseError", err)
+			}
+			if got := exitCodeForError(err); got != StateUnknown {
+				t.Errorf("exit state = %d, want %d", got, StateUnknown)
+			}
+		})
+	}
+}
+
+func TestFetchServerInfoVersionFormat(t *testing.T) {
+	fixture := readFixture(t, "serverinfo.json")
+
+	tests := []struct {
+		version string
+		wantErr bool
+	}{
+		{"30.0.4.1", false},
+		{"31.0.0", false},
+		{"30.0", true},
+		{"30.0.4.1-beta", true},
+		{"v30.0.4", true},
+		{"2.4.0 (build 123)", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			body := bytes.Replace(fixture, []byte(`"30.0.4.1"`), []byte(strconv.Quote(tt.version)), 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(body)
+			}))
+			defer server.Close()
+
+			cfg := testFetchConfig(server.URL)
+			cfg.APIPaths = cfg.APIPaths[:1]
+			ocsResp, _, err := fetchServerInfo(context.Background(), server.Client(), cfg)
+
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("fetchServerInfo() = %v, want success", err)
+				}
+				if version := ocsResp.OCS.Data.Nextcloud.System.Version; version != tt.version {
+					t.Errorf("version = %q, want %q", version, tt.version)
+				}
+				return
+			}
+			var contentErr *ContentError
+			if !errors.As(err, &contentErr) {
+				t.Fatalf("err = %v, want ContentError", err)
+			}
+			if !strings.Contains(contentErr.Message, "unexpected version format") {
+				t.Errorf("Message = %q, want unexpected version format", contentErr.Message)
+			}
+			if got := exitCodeForError(err); got != StateUnknown {
+				t.Errorf("exit state = %d, want %d", got, StateUnknown)
+			}
+		})
+	}
+}
+
+func TestMaintenanceHeader(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"", false},
+		{"0", false},
+		{"false", false},
+		{"FALSE", false},
+		{"1", true},
+		{" 1 ", true},
+		{"true", true},
+		{"on", true},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.value != "" {
+			header.Set("X-Nextcloud-Maintenance-Mode", tt.value)
+		}
+		if got := maintenanceHeader(header); got != tt.want {
+			t.Errorf("maintenanceHeader(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFetchServerInfoMaintenance(t *testing.T) {
+	tests := []struct {
+		name  string
+		state int
+	}{
+		{"warning", StateWarning},
+		{"critical", StateCritical},
+		{"ok", StateOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Nextcloud-Maintenance-Mode", "1")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer server.Close()
+
+			cfg := testFetchConfig(server.URL)
+			cfg.MaintenanceState = tt.state
+			_, _, err := fetchServerInfo(context.Background(), server.Client(), cfg)
+
+			var maintenanceErr *MaintenanceError
+			if !errors.As(err, &maintenanceErr) {
+				t.Fatalf("err = %v, want MaintenanceError", err)
+			}
+			if maintenanceErr.State != tt.state {
+				t.Errorf("State = %d, want %d", maintenanceErr.State, tt.state)
+			}
+			if got := exitCodeForError(err); got != tt.state {
+				t.Errorf("exit state = %d, want %d", got, tt.state)
+			}
+		})
+	}
+}
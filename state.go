@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// State is persisted in --state-file between runs so checks can compare the
+// current response against previous ones.
+type State struct {
+	Timestamp int64 `json:"timestamp"`
+	// Version is the highest Nextcloud version seen so far. It is not
+	// lowered on a downgrade so the regression keeps alerting until the
+	// state file is reset.
+	Version string `json:"version"`
+	// MemoryUsagePercent is nil when no previous value was recorded.
+	MemoryUsagePercent *float64 `json:"memory_usage_percent,omitempty"`
+	// NumFiles is nil when no previous value was recorded.
+	NumFiles *int `json:"num_files,omitempty"`
+	// FileSamples holds num_files at most once per fileSampleInterval for
+	// the --files-window, oldest first.
+	FileSamples []FileSample `json:"file_samples,omitempty"`
+	// OpcacheOOMRestarts is nil when no previous value was recorded.
+	OpcacheOOMRestarts *int `json:"opcache_oom_restarts,omitempty"`
+	// NumStorages is nil when no previous value was recorded.
+	NumStorages *int `json:"num_storages,omitempty"`
+	// Breaches counts the consecutive breaching runs per metric for
+	// --grace-period. Metrics within their thresholds are not listed.
+	Breaches map[string]BreachCount `json:"breaches,omitempty"`
+	// SecurityScan caches the security scanner grade, nil until the first
+	// --security-scan-min-grade run.
+	SecurityScan *SecurityScan `json:"security_scan,omitempty"`
+}
+
+// FileSample is a num_files reading at a point in time.
+type FileSample struct {
+	Timestamp int64 `json:"timestamp"`
+	NumFiles  int   `json:"num_files"`
+}
+
+// fileSampleInterval is the minimum distance between stored file samples,
+// which bounds the state file size independent of the check interval.
+const fileSampleInterval = 3600
+
+// recordFileSample appends the current reading to the samples of prev and
+// drops the ones that fell out of the window. The oldest remaining sample is
+// the baseline of the growth rate.
+func recordFileSample(prev []FileSample, sample FileSample, window int64) []FileSample {
+	samples := []FileSample{}
+	for _, s := range prev {
+		if sample.Timestamp-s.Timestamp <= window {
+			samples = append(samples, s)
+		}
+	}
+	if len(samples) == 0 || sample.Timestamp-samples[len(samples)-1].Timestamp >= fileSampleInterval {
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+// loadState reads the state file. A missing file is not an error and yields
+// nil, a corrupt one is reported on stderr and treated as missing.
+func loadState(path string) *State {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read state file: %v\n", err)
+		return nil
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse state file: %v\n", err)
+		return nil
+	}
+	return &state
+}
+
+// saveState writes the state file atomically. Failures are reported on
+// stderr only and never change the check result.
+func saveState(path string, state *State) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode state file: %v\n", err)
+		return
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write state file: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write state file: %v\n", err)
+	}
+}
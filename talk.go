@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type TalkHPBWelcome struct {
+	Signaling string `json:"nextcloud-spreed-signaling"`
+	Version   string `json:"version"`
+}
+
+// checkTalkHPB queries the welcome endpoint of a Talk high-performance backend
+// (e.g. https://signaling.example.com/api/v1/welcome) and returns its version.
+func checkTalkHPB(ctx context.Context, client *http.Client, hpbURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", hpbURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "check_nextcloud/1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var welcome TalkHPBWelcome
+	if err := json.NewDecoder(resp.Body).Decode(&welcome); err != nil {
+		return "", fmt.Errorf("invalid welcome response: %v", err)
+	}
+	if welcome.Signaling == "" {
+		return "", fmt.Errorf("not a signaling server welcome response")
+	}
+
+	return welcome.Version, nil
+}
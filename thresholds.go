@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Built-in memory and swap usage thresholds in percent.
+const (
+	memoryWarnPercent = 80
+	memoryCritPercent = 90
+	swapWarnPercent   = 80
+	swapCritPercent   = 90
+)
+
+// parseLoadThresholds parses a "1m,5m,15m" list of load average thresholds.
+func parseLoadThresholds(spec string) ([3]float64, error) {
+	var thresholds [3]float64
+
+	parts := strings.Split(spec, ",")
+	if len(parts) != 3 {
+		return thresholds, fmt.Errorf("expected three comma-separated values, got %q", spec)
+	}
+
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return thresholds, fmt.Errorf("invalid load threshold %q", part)
+		}
+		thresholds[i] = value
+	}
+
+	return thresholds, nil
+}
+
+// ThresholdPair describes a warn/crit flag pair for startup validation.
+type ThresholdPair struct {
+	// Flag is the common flag prefix, e.g. "users-percent" for
+	// --users-percent-warn and --users-percent-crit.
+	Flag string
+	Warn float64
+	Crit float64
+	// LowerIsWorse is set for metrics like hit rates where falling values
+	// are the problem, so warn must not be below crit.
+	LowerIsWorse bool
+	// WarnOnly is set for flags without a --<flag>-crit counterpart, only
+	// Warn is validated then.
+	WarnOnly bool
+	// Percent limits the thresholds to 0-100.
+	Percent bool
+	// ZeroDisables exempts a threshold set to 0 from the ordering check, for
+	// pairs where either severity may be disabled on its own.
+	ZeroDisables bool
+}
+
+// validateThresholds rejects warn/crit pairs that are ordered illogically for
+// the direction of their metric, which would silently invert alerting, and
+// thresholds outside the range of their metric.
+func validateThresholds(pairs []ThresholdPair) error {
+	for _, pair := range pairs {
+		if err := validateThreshold("--"+pair.Flag+"-warn", pair.Warn, pair.Percent); err != nil {
+			return err
+		}
+		if !pair.WarnOnly {
+			if err := validateThreshold("--"+pair.Flag+"-crit", pair.Crit, pair.Percent); err != nil {
+				return err
+			}
+		}
+
+		if pair.WarnOnly || (pair.ZeroDisables && (pair.Warn == 0 || pair.Crit == 0)) {
+			continue
+		}
+		if !pair.LowerIsWorse && pair.Warn > pair.Crit {
+			return fmt.Errorf("--%s-warn (%v) must not be above --%s-crit (%v), higher values are worse", pair.Flag, pair.Warn, pair.Flag, pair.Crit)
+		}
+		if pair.LowerIsWorse && pair.Warn < pair.Crit {
+			return fmt.Errorf("--%s-warn (%v) must not be below --%s-crit (%v), lower values are worse", pair.Flag, pair.Warn, pair.Flag, pair.Crit)
+		}
+	}
+	return nil
+}
+
+// validateThreshold checks the range of a single threshold flag.
+func validateThreshold(flag string, value float64, percent bool) error {
+	if value < 0 {
+		return fmt.Errorf("%s (%v) must not be negative", flag, value)
+	}
+	if percent && value > 100 {
+		return fmt.Errorf("%s (%v) must not be above 100 percent", flag, value)
+	}
+	return nil
+}
+
+// Breach is a condition that raised the state of a run, listed in the
+// breaches of the --output json document. Metric is the perfdata metric, or
+// the check name for conditions without one; Value and Threshold are
+// omitted when the condition has none.
+type Breach struct {
+	Metric    string      `json:"metric"`
+	Value     interface{} `json:"value,omitempty"`
+	Threshold string      `json:"threshold,omitempty"`
+	Severity  string      `json:"severity"`
+	Message   string      `json:"message"`
+}
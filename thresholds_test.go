@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateThresholds(t *testing.T) {
+	tests := []struct {
+		name    string
+		pair    ThresholdPair
+		wantErr string
+	}{
+		{"higher is worse ordered", ThresholdPair{Flag: "users-percent", Warn: 80, Crit: 90}, ""},
+		{"higher is worse equal", ThresholdPair{Flag: "users-percent", Warn: 90, Crit: 90}, ""},
+		{"higher is worse inverted", ThresholdPair{Flag: "users-percent", Warn: 95, Crit: 90}, "must not be above --users-percent-crit"},
+		{"lower is worse ordered", ThresholdPair{Flag: "opcache-hit-rate", Warn: 95, Crit: 80, LowerIsWorse: true}, ""},
+		{"lower is worse inverted", ThresholdPair{Flag: "opcache-hit-rate", Warn: 80, Crit: 95, LowerIsWorse: true}, "must not be below --opcache-hit-rate-crit"},
+		{"zero disables warn", ThresholdPair{Flag: "opcache-hit-rate", Crit: 80, LowerIsWorse: true, ZeroDisables: true}, ""},
+		{"zero disables crit", ThresholdPair{Flag: "opcache-hit-rate", Warn: 90, LowerIsWorse: true, ZeroDisables: true}, ""},
+		{"zero without disable", ThresholdPair{Flag: "opcache-hit-rate", Crit: 80, LowerIsWorse: true}, "must not be below"},
+		{"negative warn", ThresholdPair{Flag: "external-storage", Warn: -1, Crit: 95}, "--external-storage-warn (-1) must not be negative"},
+		{"negative crit", ThresholdPair{Flag: "external-storage", Warn: -5, Crit: -1}, "--external-storage-warn (-5) must not be negative"},
+		{"percent above 100", ThresholdPair{Flag: "external-storage", Warn: 90, Crit: 101, Percent: true}, "--external-storage-crit (101) must not be above 100 percent"},
+		{"warn only ignores crit", ThresholdPair{Flag: "db-slow-query-percent", Warn: 5, WarnOnly: true, Percent: true}, ""},
+		{"warn only percent", ThresholdPair{Flag: "opcache-keys-percent", Warn: 150, WarnOnly: true, Percent: true}, "--opcache-keys-percent-warn (150) must not be above 100 percent"},
+		{"warn only negative", ThresholdPair{Flag: "shares-per-user", Warn: -2, WarnOnly: true}, "--shares-per-user-warn (-2) must not be negative"},
+		{"warn only unbounded", ThresholdPair{Flag: "object-storage-latency", Warn: 250, WarnOnly: true}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateThresholds([]ThresholdPair{tt.pair})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateThresholds() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validateThresholds() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOpcacheHitRateThresholds(t *testing.T) {
+	tests := []struct {
+		name       string
+		warn, crit float64
+		wantExit   int
+		wantStatus string
+		wantPerf   string
+	}{
+		{"above both", 90, 80, StateOK, "OK", "opcache_hit_rate=96.2;90:;80: "},
+		{"below warn", 97, 80, StateWarning, "WARNING - PHP Opcache Hit Rate 96.2%", "opcache_hit_rate=96.2;97:;80: "},
+		{"below crit", 99, 97, StateCritical, "CRITICAL - PHP Opcache Hit Rate 96.2%", "opcache_hit_rate=96.2;99:;97: "},
+		{"crit only", 0, 97, StateCritical, "CRITICAL - PHP Opcache Hit Rate 96.2%", "opcache_hit_rate=96.2;;97: "},
+		{"disabled", 0, 0, StateOK, "OK", "opcache_hit_rate=96.2 "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, exitCode, err := runFixtureCheck(t, readFixture(t, "serverinfo.json"), func(cfg *Config) {
+				cfg.OpcacheHitRateWarn = tt.warn
+				cfg.OpcacheHitRateCrit = tt.crit
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exitCode != tt.wantExit {
+				t.Errorf("exit code = %d, want %d: %s", exitCode, tt.wantExit, output)
+			}
+			if !strings.HasPrefix(output, tt.wantStatus) {
+				t.Errorf("output %q does not start with %q", output, tt.wantStatus)
+			}
+			if perfdata := splitPerfdata(t, output) + " "; !strings.Contains(perfdata, tt.wantPerf) {
+				t.Errorf("perfdata lacks %q: %s", tt.wantPerf, perfdata)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// compareVersions compares two dotted numeric versions segment by segment,
+// treating missing segments as zero (so 30.0 equals 30.0.0.0). It returns -1,
+// 0 or 1 when a is lower than, equal to or greater than b.
+func compareVersions(a, b string) (int, error) {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		aNum, err := versionSegment(aParts, i)
+		if err != nil {
+			return 0, fmt.Errorf("invalid version %q: %v", a, err)
+		}
+		bNum, err := versionSegment(bParts, i)
+		if err != nil {
+			return 0, fmt.Errorf("invalid version %q: %v", b, err)
+		}
+		if aNum < bNum {
+			return -1, nil
+		}
+		if aNum > bNum {
+			return 1, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// normalizeVersion pads a dotted numeric version with zero segments to the
+// four segments Nextcloud uses internally, e.g. 30.0.9 becomes 30.0.9.0.
+// Other versions are returned unchanged.
+func normalizeVersion(version string) string {
+	parts := strings.Split(version, ".")
+	if len(parts) >= 4 {
+		return version
+	}
+	for _, part := range parts {
+		if _, err := strconv.Atoi(part); err != nil {
+			return version
+		}
+	}
+	return version + strings.Repeat(".0", 4-len(parts))
+}
+
+func versionSegment(parts []string, i int) (int, error) {
+	if i >= len(parts) {
+		return 0, nil
+	}
+	return strconv.Atoi(parts[i])
+}
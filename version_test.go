@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{"30", "30.0.0.0"},
+		{"30.0", "30.0.0.0"},
+		{"30.0.9", "30.0.9.0"},
+		{"30.0.4.1", "30.0.4.1"},
+		{"30.0.4.1.2", "30.0.4.1.2"},
+		{"30.0.0-beta", "30.0.0-beta"},
+		{"30..1", "30..1"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeVersion(tt.version); got != tt.want {
+			t.Errorf("normalizeVersion(%q) = %q, want %q", tt.version, got, tt.want)
+		}
+	}
+}
+
+// TestNormalizeVersionOutput checks that --normalize-version pads the
+// version in the structured output only.
+func TestNormalizeVersionOutput(t *testing.T) {
+	fixture := bytes.Replace(readFixture(t, "serverinfo.json"), []byte(`"30.0.4.1"`), []byte(`"30.0.9"`), 1)
+
+	tests := []struct {
+		normalize bool
+		want      string
+	}{
+		{false, "30.0.9"},
+		{true, "30.0.9.0"},
+	}
+
+	for _, tt := range tests {
+		output, _, err := runFixtureCheck(t, fixture, func(cfg *Config) {
+			cfg.Output = "json"
+			cfg.NormalizeVersion = tt.normalize
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var summary HealthSummary
+		if err := json.Unmarshal([]byte(output), &summary); err != nil {
+			t.Fatalf("invalid json %q: %v", output, err)
+		}
+		if summary.Version != tt.want {
+			t.Errorf("normalize %v: version = %q, want %q", tt.normalize, summary.Version, tt.want)
+		}
+
+		output, _, err = runFixtureCheck(t, fixture, func(cfg *Config) {
+			cfg.NormalizeVersion = tt.normalize
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(output, "Nextcloud 30.0.9 running.") {
+			t.Errorf("normalize %v: status line %q does not keep the reported version", tt.normalize, output)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// webhookTimeout bounds the webhook request independent of --timeout.
+const webhookTimeout = 10 * time.Second
+
+// WebhookPayload is the JSON body posted to --webhook-url: the --output json
+// document plus the message as text (Slack) and content (Discord), so chat
+// webhooks can be used directly.
+type WebhookPayload struct {
+	HealthSummary
+	Text    string `json:"text"`
+	Content string `json:"content"`
+}
+
+// webhookMinState parses --webhook-on into the least severe state that is
+// sent, ordered like severityRank.
+func webhookMinState(name string) (int, error) {
+	state := stateByName(name)
+	if state <= StateOK {
+		return 0, fmt.Errorf("unknown state %q (supported: warning, unknown, critical)", name)
+	}
+	return state, nil
+}
+
+// sendWebhook posts summary when its state is at least minState. The token
+// is redacted from the payload. Failures are reported on stderr only and
+// never change the check result.
+func sendWebhook(ctx context.Context, client *http.Client, webhookURL string, summary HealthSummary, minState int, token string) {
+	if severityRank[summary.ExitCode] < severityRank[minState] {
+		return
+	}
+
+	if token != "" {
+		summary.Host = strings.ReplaceAll(summary.Host, token, "[REDACTED]")
+		summary.Message = strings.ReplaceAll(summary.Message, token, "[REDACTED]")
+	}
+	data, err := json.Marshal(WebhookPayload{HealthSummary: summary, Text: summary.Message, Content: summary.Message})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode webhook payload: %v\n", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", webhookURL, bytes.NewReader(data))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to send webhook: %v\n", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "check_nextcloud/1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to send webhook: %v\n", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		fmt.Fprintf(os.Stderr, "Failed to send webhook: unexpected status %d\n", resp.StatusCode)
+	}
+}